@@ -0,0 +1,185 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// POSIX ACLs are stored by Linux in the system.posix_acl_access and
+// system.posix_acl_default xattrs as a version followed by entries of
+// a tag, permissions, and ID. Archives hold them in the SCHILY.acl.access
+// and SCHILY.acl.default PAX records in the comma separated text form
+// of star and GNU tar, such as user::rw-,user:1000:r--,group::r--,
+// mask::r--,other::---, with numeric IDs.
+
+const (
+	aclVersion   = 2
+	aclEntrySize = 8
+	aclNoID      = 0xffffffff
+)
+
+var aclTags = []struct {
+	tag  uint16
+	name string
+	id   bool
+}{
+	{0x01, "user", false},
+	{0x02, "user", true},
+	{0x04, "group", false},
+	{0x08, "group", true},
+	{0x10, "mask", false},
+	{0x20, "other", false},
+}
+
+var (
+	ErrInvalidACL = errors.New("archive: invalid ACL")
+)
+
+// aclText returns the text form of an ACL xattr.
+func aclText(b []byte) (string, error) {
+	if len(b) < 4 || (len(b)-4)%aclEntrySize != 0 {
+		return "", ErrInvalidACL
+	}
+
+	if binary.LittleEndian.Uint32(b) != aclVersion {
+		return "", ErrInvalidACL
+	}
+
+	var entries []string
+	for b = b[4:]; len(b) > 0; b = b[aclEntrySize:] {
+		tag := binary.LittleEndian.Uint16(b[0:])
+		perm := binary.LittleEndian.Uint16(b[2:])
+		id := binary.LittleEndian.Uint32(b[4:])
+
+		entry := ""
+		for _, t := range aclTags {
+			switch {
+			case t.tag != tag:
+			case t.id:
+				entry = fmt.Sprintf("%s:%d:%s", t.name, id, aclPerms(perm))
+			default:
+				entry = fmt.Sprintf("%s::%s", t.name, aclPerms(perm))
+			}
+		}
+
+		if entry == "" {
+			return "", ErrInvalidACL
+		}
+		entries = append(entries, entry)
+	}
+
+	return strings.Join(entries, ","), nil
+}
+
+// aclBinary returns the ACL xattr for the text form of an ACL. Entries
+// may be separated by commas or newlines, qualifiers may be names or
+// IDs, and a trailing ID after the permissions is used when present.
+func aclBinary(s string) ([]byte, error) {
+	b := make([]byte, 4, 4+aclEntrySize*8)
+	binary.LittleEndian.PutUint32(b, aclVersion)
+
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+
+	for _, entry := range entries {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, ErrInvalidACL
+		}
+
+		name, qualifier := fields[0], fields[1]
+		if len(fields) == 4 {
+			qualifier = fields[3]
+		}
+
+		perm, err := aclPerm(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		tag, id := uint16(0), uint32(aclNoID)
+		for _, t := range aclTags {
+			if t.name == name && t.id == (qualifier != "") {
+				tag = t.tag
+			}
+		}
+
+		switch {
+		case tag == 0:
+			return nil, ErrInvalidACL
+		case qualifier != "":
+			if id, err = aclID(name, qualifier); err != nil {
+				return nil, err
+			}
+		}
+
+		var e [aclEntrySize]byte
+		binary.LittleEndian.PutUint16(e[0:], tag)
+		binary.LittleEndian.PutUint16(e[2:], perm)
+		binary.LittleEndian.PutUint32(e[4:], id)
+		b = append(b, e[:]...)
+	}
+
+	return b, nil
+}
+
+func aclPerms(perm uint16) string {
+	b := []byte("---")
+	for i, c := range "rwx" {
+		if perm&(4>>uint(i)) != 0 {
+			b[i] = byte(c)
+		}
+	}
+	return string(b)
+}
+
+func aclPerm(s string) (uint16, error) {
+	if len(s) != 3 {
+		return 0, ErrInvalidACL
+	}
+
+	var perm uint16
+	for i, c := range "rwx" {
+		switch s[i] {
+		case byte(c):
+			perm |= 4 >> uint(i)
+		case '-':
+		default:
+			return 0, ErrInvalidACL
+		}
+	}
+	return perm, nil
+}
+
+// aclID returns the ID of a user or group qualifier.
+func aclID(tag, qualifier string) (uint32, error) {
+	if id, err := strconv.ParseUint(qualifier, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	var id string
+	switch tag {
+	case "user":
+		u, err := user.Lookup(qualifier)
+		if err != nil {
+			return 0, err
+		}
+		id = u.Uid
+	default:
+		g, err := user.LookupGroup(qualifier)
+		if err != nil {
+			return 0, err
+		}
+		id = g.Gid
+	}
+
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestACLText(t *testing.T) {
+	tests := []string{
+		"user::rw-,group::r--,other::---",
+		"user::rwx,user:1000:r-x,group::r--,group:100:-w-,mask::rwx,other::--x",
+	}
+
+	for _, text := range tests {
+		b, err := aclBinary(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) != 4+aclEntrySize*len(split(text, ',')) {
+			t.Fatalf("ACL %s encoded to %d bytes", text, len(b))
+		}
+
+		if s, err := aclText(b); err != nil || s != text {
+			t.Fatalf("ACL %s decoded to %q, %v", text, s, err)
+		}
+	}
+}
+
+func TestACLTextForms(t *testing.T) {
+	tests := []struct {
+		text  string
+		canon string
+	}{
+		{"user::rw-\ngroup::r--\nother::---\n", "user::rw-,group::r--,other::---"},
+		{"user:alice:r--:1000,mask::r--", "user:1000:r--,mask::r--"},
+	}
+
+	for _, test := range tests {
+		b, err := aclBinary(test.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s, _ := aclText(b); s != test.canon {
+			t.Fatalf("ACL %q decoded to %q", test.text, s)
+		}
+	}
+}
+
+func TestInvalidACL(t *testing.T) {
+	for _, text := range []string{"user", "user::rwxr", "mask:1000:r--", "owner::r--", "user::r-w"} {
+		if _, err := aclBinary(text); err != ErrInvalidACL {
+			t.Fatalf("invalid ACL %s got %v", text, err)
+		}
+	}
+
+	for _, b := range [][]byte{nil, {1, 0, 0, 0}, {2, 0, 0, 0, 1}, {2, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0}} {
+		if _, err := aclText(b); err != ErrInvalidACL {
+			t.Fatalf("invalid ACL %x got %v", b, err)
+		}
+	}
+}
@@ -4,48 +4,67 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/codahale/sss"
-	"github.com/magical/argon2"
+	"github.com/dchest/blake2b"
 	"github.com/wg/arc/archive"
 	"github.com/wg/arc/binary"
+	"github.com/wg/arc/ida"
 )
 
 const (
-	Version  = 0x01
+	Version  = 0x08
 	Password = 0x01
 	Curve448 = 0x02
 	Shard    = 0x03
 	KeySize  = archive.KeySize
+	SumSize  = 32
+	SetSize  = 16
+)
+
+const (
+	Signed    = 0x01
+	Dispersed = 0x02
 )
 
 type Archiver interface {
 	Reader() (*Reader, error)
 	Writer() (*Writer, error)
+	SetSigning(*SigningPrivateKey, *SigningPublicKey)
 }
 
 type Reader struct {
-	buffer *bufio.Reader
-	files  []File
+	key       *Secret
+	files     []File
+	damaged   []File
+	dispersal *dispersal
+	closers   []io.Closer
 	*archive.Reader
 }
 
 type Writer struct {
-	buffer *bufio.Writer
-	files  []File
-	tagAt  int64
+	key     *Secret
+	buffer  *bufio.Writer
+	closers []io.Closer
 	*archive.Writer
 }
 
 var (
-	ErrInvalidArchive  = errors.New("archive: verify failed")
+	ErrInvalidArchive  = archive.ErrVerifyFailed
 	ErrInvalidVersion  = errors.New("archive: unsupported version")
+	ErrInvalidType     = errors.New("archive: unsupported type")
+	ErrCorruptHeader   = errors.New("archive: header corrupt")
 	ErrPasswordArchive = errors.New("archive: password archive")
 	ErrCurve448Archive = errors.New("archive: curve448 archive")
 	ErrShardArchive    = errors.New("archive: shard archive")
+	ErrNotRecipient    = errors.New("archive: private key is not a recipient")
+	ErrMixedShards     = errors.New("archive: shards from different sets")
 )
 
 // A PasswordArchive is encrypted with a key derived from a password,
@@ -54,17 +73,23 @@ var (
 type PasswordArchive struct {
 	Version    byte
 	Type       byte
+	Flags      byte
+	Variant    byte
+	Lanes      byte
 	Iterations uint32
 	Memory     uint32
 	Salt       [32]byte
-	Password   []byte
+	Password   *Secret
 	File       File
+	Signing
 }
 
-func NewPasswordArchive(password []byte, iterations, memory uint32, file File) *PasswordArchive {
+func NewPasswordArchive(password *Secret, iterations, memory uint32, lanes byte, file File) *PasswordArchive {
 	return &PasswordArchive{
 		Version:    Version,
 		Type:       Password,
+		Variant:    Argon2id,
+		Lanes:      lanes,
 		Iterations: iterations,
 		Memory:     memory,
 		Password:   password,
@@ -73,18 +98,35 @@ func NewPasswordArchive(password []byte, iterations, memory uint32, file File) *
 }
 
 func (a *PasswordArchive) Reader() (*Reader, error) {
-	err := binary.Read(a.File, binary.LE, a)
+	header, err := readHeader(a.File, a)
 	if err != nil {
 		return nil, err
 	}
 
 	switch {
+	case a.Version == LegacyVersion:
+		return a.legacyReader()
 	case a.Version != Version:
-		return nil, ErrInvalidVersion
+		return nil, versionError(a.Version)
 	case a.Type == Curve448:
 		return nil, ErrCurve448Archive
 	case a.Type == Shard:
 		return nil, ErrShardArchive
+	case a.Type == KeySlots:
+		return nil, ErrKeySlotArchive
+	}
+
+	if err = a.check(a.Flags); err != nil {
+		return nil, err
+	}
+
+	ad, err := readDigest(a.File, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = Limits.Check(a.Iterations, a.Memory); err != nil {
+		return nil, err
 	}
 
 	key, err := a.Key()
@@ -92,7 +134,7 @@ func (a *PasswordArchive) Reader() (*Reader, error) {
 		return nil, err
 	}
 
-	return newArchiveReader(key, a.File, a.File)
+	return newArchiveReader(key, ad, &a.Signing, a.File, a.File)
 }
 
 func (a *PasswordArchive) Writer() (*Writer, error) {
@@ -101,7 +143,9 @@ func (a *PasswordArchive) Writer() (*Writer, error) {
 		return nil, err
 	}
 
-	err = binary.Write(a.File, binary.LE, a)
+	a.Flags = a.flags()
+
+	ad, err := writeHeader(a.File, a)
 	if err != nil {
 		return nil, err
 	}
@@ -111,97 +155,185 @@ func (a *PasswordArchive) Writer() (*Writer, error) {
 		return nil, err
 	}
 
-	return newArchiveWriter(key, a.File, a.File)
+	return newArchiveWriter(key, ad, &a.Signing, a.File, a.File)
 }
 
-func (a *PasswordArchive) Key() ([]byte, error) {
-	var (
-		password   = a.Password
-		salt       = a.Salt[:]
-		iterations = int(a.Iterations)
-		memory     = int64(a.Memory)
-	)
-	return argon2.Key(password, salt, iterations, 1, memory, KeySize)
+func (a *PasswordArchive) Key() (*Secret, error) {
+	return deriveKey(a.Password, a.Salt[:], a.Variant, a.Lanes, a.Iterations, a.Memory, KeySize)
 }
 
-// A Curve448Archive is encrypted with a key derived from applying
-// BLAKE2b to the shared secret derived from an X448 ECDH key exchange
-// with an ephemeral private key and static public key.
+// A Curve448Archive is encrypted with a key consisting of
+// cryptographically secure random bytes. That key is encrypted once
+// for each recipient with a key derived from applying BLAKE2b to the
+// shared secret derived from an X448 ECDH key exchange with an
+// ephemeral private key and the recipient's static public key.
 type Curve448Archive struct {
 	Version    byte
 	Type       byte
+	Flags      byte
+	Count      byte
 	Ephemeral  PublicKey
-	PublicKey  *PublicKey
+	Recipients []Recipient
+	PublicKeys []*PublicKey
 	PrivateKey *PrivateKey
 	File       File
+	Signing
 }
 
-func NewCurve448Archive(public *PublicKey, private *PrivateKey, file File) *Curve448Archive {
+// A Recipient holds the archive key encrypted for one public key.
+type Recipient struct {
+	Tag   [TagSize]byte
+	Nonce [NonSize]byte
+	Key   [KeySize]byte
+}
+
+func NewCurve448Archive(public []*PublicKey, private *PrivateKey, file File) *Curve448Archive {
 	return &Curve448Archive{
 		Version:    Version,
 		Type:       Curve448,
-		PublicKey:  public,
+		PublicKeys: public,
 		PrivateKey: private,
 		File:       file,
 	}
 }
 
 func (a *Curve448Archive) Reader() (*Reader, error) {
-	err := binary.Read(a.File, binary.LE, a)
+	header, err := readHeader(a.File, a)
 	if err != nil {
 		return nil, err
 	}
 
 	switch {
+	case a.Version == LegacyVersion:
+		return a.legacyReader()
 	case a.Version != Version:
-		return nil, ErrInvalidVersion
+		return nil, versionError(a.Version)
 	case a.Type == Password:
 		return nil, ErrPasswordArchive
 	case a.Type == Shard:
 		return nil, ErrShardArchive
+	case a.Type == KeySlots:
+		return nil, ErrKeySlotArchive
+	}
+
+	if err = a.check(a.Flags); err != nil {
+		return nil, err
+	}
+
+	a.Recipients = make([]Recipient, a.Count)
+	for i := range a.Recipients {
+		b, err := readHeader(a.File, &a.Recipients[i])
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, b...)
+	}
+
+	ad, err := readDigest(a.File, header)
+	if err != nil {
+		return nil, err
 	}
 
-	key, err := ComputeSharedKey(&a.Ephemeral, a.PrivateKey, KeySize)
+	shared, err := ComputeSharedKey(&a.Ephemeral, a.PrivateKey, KeySize)
 	if err != nil {
 		return nil, err
 	}
+	defer shared.Close()
 
-	return newArchiveReader(key, a.File, a.File)
+	for _, r := range a.Recipients {
+		if key, ok := r.Open(shared); ok {
+			return newArchiveReader(key, ad, &a.Signing, a.File, a.File)
+		}
+	}
+
+	return nil, ErrNotRecipient
 }
 
 func (a *Curve448Archive) Writer() (*Writer, error) {
-	ephemeralPublicKey, ephemeralPrivateKey, err := GenerateKeypair()
+	key := NewSecret(KeySize)
+
+	_, err := rand.Read(key.Bytes())
 	if err != nil {
+		key.Close()
 		return nil, err
 	}
-	defer ephemeralPrivateKey.Zero()
 
-	key, err := ComputeSharedKey(a.PublicKey, ephemeralPrivateKey, KeySize)
+	ephemeralPublicKey, ephemeralPrivateKey, err := GenerateKeypair()
 	if err != nil {
+		key.Close()
 		return nil, err
 	}
+	defer ephemeralPrivateKey.Zero()
 
+	a.Flags = a.flags()
+	a.Count = byte(len(a.PublicKeys))
 	a.Ephemeral = *ephemeralPublicKey
-	err = binary.Write(a.File, binary.LE, a)
+	a.Recipients = make([]Recipient, len(a.PublicKeys))
+
+	headers := []interface{}{a}
+	for i, public := range a.PublicKeys {
+		shared, err := ComputeSharedKey(public, ephemeralPrivateKey, KeySize)
+		if err != nil {
+			key.Close()
+			return nil, err
+		}
+
+		err = a.Recipients[i].Seal(shared, key)
+		shared.Close()
+		if err != nil {
+			key.Close()
+			return nil, err
+		}
+
+		headers = append(headers, &a.Recipients[i])
+	}
+
+	ad, err := writeHeader(a.File, headers...)
 	if err != nil {
+		key.Close()
 		return nil, err
 	}
 
-	return newArchiveWriter(key, a.File, a.File)
+	return newArchiveWriter(key, ad, &a.Signing, a.File, a.File)
+}
+
+// Seal encrypts key with a random nonce and the shared key.
+func (r *Recipient) Seal(shared, key *Secret) error {
+	return SealKey(shared, key, &r.Tag, &r.Nonce, &r.Key)
+}
+
+// Open decrypts the key with the shared key and reports whether it
+// was authenticated.
+func (r *Recipient) Open(shared *Secret) (*Secret, bool) {
+	return OpenKey(shared, &r.Tag, &r.Nonce, &r.Key)
 }
 
 // A ShardArchive is encrypted with a key consisting of cryptographically
 // secure random bytes. That key is split into n shards using Shamir's
 // Secret Sharing algorithm and one archive is generate for each shard.
-// k shards must be present to recreate the key.
+// k shards must be present to recreate the key. The encrypted data is
+// shared by every shard so only the header fields common to all shards
+// are bound to it, a corrupt share instead yields the wrong key.
+//
+// Every shard records the random ID of its set along with the threshold
+// k and count n so that shards of different sets are never combined.
+//
+// When Disperse is set the encrypted data is not copied to every shard
+// but split with Rabin's information dispersal algorithm so that each
+// shard holds 1/k of it and any k shards recreate it.
 type ShardArchive struct {
 	Version   byte
 	Type      byte
+	Flags     byte
+	Set       [SetSize]byte
 	ID        byte
+	Threshold byte
+	Count     byte
 	Share     [KeySize]byte
-	Threshold int
+	Disperse  bool
 	File      File
 	Shards    []*ShardArchive
+	Signing
 }
 
 func NewShardArchive(threshold int, files []File) *ShardArchive {
@@ -211,7 +343,8 @@ func NewShardArchive(threshold int, files []File) *ShardArchive {
 		shards[i] = &ShardArchive{
 			Version:   Version,
 			Type:      Shard,
-			Threshold: threshold,
+			Threshold: byte(threshold),
+			Count:     byte(len(files)),
 			File:      file,
 			Shards:    shards,
 		}
@@ -220,65 +353,204 @@ func NewShardArchive(threshold int, files []File) *ShardArchive {
 	return shards[0]
 }
 
+// NewDispersedShardArchive returns a ShardArchive that disperses the
+// encrypted data across its shards.
+func NewDispersedShardArchive(threshold int, files []File) *ShardArchive {
+	a := NewShardArchive(threshold, files)
+	a.Disperse = true
+	return a
+}
+
 func (a *ShardArchive) Reader() (*Reader, error) {
+	var intact []*ShardArchive
+	var damaged []File
+	var err error
+
 	shares := make(map[byte][]byte, len(a.Shards))
 
 	for _, shard := range a.Shards {
-		err := binary.Read(shard.File, binary.LE, shard)
-		if err != nil {
-			return nil, err
+		var header []byte
+		if header, err = readHeader(shard.File, shard); err != nil {
+			damaged = append(damaged, shard.File)
+			continue
 		}
 
 		switch {
+		case shard.Version == LegacyVersion:
+			return a.legacyReader()
 		case shard.Version != Version:
-			return nil, ErrInvalidVersion
+			return nil, versionError(shard.Version)
 		case shard.Type == Password:
 			return nil, ErrPasswordArchive
 		case shard.Type == Curve448:
 			return nil, ErrCurve448Archive
+		case shard.Type == KeySlots:
+			return nil, ErrKeySlotArchive
+		}
+
+		if err = a.check(shard.Flags); err != nil {
+			return nil, err
 		}
 
+		if _, err = readDigest(shard.File, header); err != nil {
+			damaged = append(damaged, shard.File)
+			continue
+		}
+
+		intact = append(intact, shard)
 		shares[shard.ID] = shard.Share[:]
 	}
 
-	key := sss.Combine(shares)
+	if len(intact) == 0 {
+		return nil, err
+	}
+
+	head := intact[0]
+	for _, shard := range intact {
+		switch {
+		case shard.Set != head.Set:
+			return nil, ErrMixedShards
+		case shard.Flags != head.Flags:
+			return nil, ErrMixedShards
+		case shard.Threshold != head.Threshold || shard.Count != head.Count:
+			return nil, ErrMixedShards
+		case shard.ID == 0 || shard.ID > shard.Count:
+			return nil, ErrCorruptHeader
+		}
+	}
+
+	if len(shares) < int(head.Threshold) {
+		return nil, &MissingShardsError{head, int(head.Threshold) - len(shares)}
+	}
+
+	key := CopySecret(sss.Combine(shares))
+
+	if head.Flags&Dispersed == 0 {
+		files := make([]File, len(intact))
+		readers := make([]io.Reader, len(intact))
+		for i, shard := range intact {
+			files[i] = shard.File
+			readers[i] = shard.File
+		}
 
-	return newArchiveReader(key, a.File, a.Files()...)
+		r, err := newReplicaReader(key, head.Digest(), &a.Signing, readers, a.closers()...)
+		r.files = files
+		r.damaged = damaged
+		return r, err
+	}
+
+	var shards []*ShardArchive
+	ids := make([]byte, 0, len(intact))
+	for _, shard := range intact {
+		if bytes.IndexByte(ids, shard.ID) == -1 {
+			ids = append(ids, shard.ID)
+			shards = append(shards, shard)
+		}
+	}
+
+	d, readers, err := newDispersal(shards, int(head.Threshold))
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	r, err := newReplicaReader(key, head.Digest(), &a.Signing, readers, a.closers()...)
+	r.dispersal = d
+	r.damaged = damaged
+	return r, err
 }
 
 func (a *ShardArchive) Writer() (*Writer, error) {
-	var key [32]byte
+	key := NewSecret(KeySize)
 
-	_, err := rand.Read(key[:])
+	_, err := rand.Read(key.Bytes())
 	if err != nil {
+		key.Close()
 		return nil, err
 	}
 
 	n := byte(len(a.Shards))
-	k := byte(a.Threshold)
+	k := a.Threshold
 
-	shares, err := sss.Split(n, k, key[:])
+	shares, err := sss.Split(n, k, key.Bytes())
 	if err != nil {
+		key.Close()
 		return nil, err
 	}
+	defer func() {
+		for _, share := range shares {
+			zero(share)
+		}
+	}()
 
+	var set [SetSize]byte
+	if _, err = rand.Read(set[:]); err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	flags := a.flags()
+	if a.Disperse {
+		flags |= Dispersed
+	}
+
+	ids := make([]byte, len(a.Shards))
 	writers := make([]io.Writer, len(a.Shards))
 	for id, share := range shares {
 		index := id - 1
 		shard := a.Shards[index]
 
+		shard.Flags = flags
+		shard.Set = set
 		shard.ID = id
+		shard.Threshold = k
+		shard.Count = n
 		copy(shard.Share[:], share)
 
-		err = binary.Write(shard.File, binary.LE, shard)
+		_, err = writeHeader(shard.File, shard)
 		if err != nil {
+			key.Close()
 			return nil, err
 		}
+		ids[index] = id
 		writers[index] = shard.File
 	}
-	w := io.MultiWriter(writers...)
 
-	return newArchiveWriter(key[:], w, a.Files()...)
+	if !a.Disperse {
+		w := io.MultiWriter(writers...)
+		return newArchiveWriter(key, a.Digest(), &a.Signing, w, a.closers()...)
+	}
+
+	w, err := ida.NewWriter(int(k), ids, writers)
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	return newArchiveWriter(key, a.Digest(), &a.Signing, w, a.closers(w)...)
+}
+
+// Digest returns the digest of the header fields shared by all shards.
+func (a *ShardArchive) Digest() []byte {
+	b := append([]byte{a.Version, a.Type, a.Flags}, a.Set[:]...)
+	sum := blake2b.Sum256(append(b, a.Threshold, a.Count))
+	return sum[:]
+}
+
+// A MissingShardsError reports that fewer shards than the threshold of
+// their set were given.
+type MissingShardsError struct {
+	*ShardArchive
+	Need int
+}
+
+func (e *MissingShardsError) Error() string {
+	shards := "shards"
+	if e.Need == 1 {
+		shards = "shard"
+	}
+	return fmt.Sprintf("archive: need %d more %s, set %x requires %d of %d",
+		e.Need, shards, e.Set, e.Threshold, e.Count)
 }
 
 func (a *ShardArchive) Files() []File {
@@ -289,54 +561,128 @@ func (a *ShardArchive) Files() []File {
 	return files
 }
 
-func newArchiveReader(key []byte, raw io.Reader, files ...File) (*Reader, error) {
-	switch valid, err := verify(key, files[0]); {
-	case err != nil:
-		return nil, err
-	case !valid:
-		return nil, ErrInvalidArchive
+// closers returns the closers followed by every shard's File.
+func (a *ShardArchive) closers(closers ...io.Closer) []io.Closer {
+	for _, shard := range a.Shards {
+		closers = append(closers, shard.File)
 	}
+	return closers
+}
 
-	buffer := bufio.NewReader(raw)
-	r, err := archive.NewReader(buffer, key)
+func newArchiveReader(key *Secret, ad []byte, s *Signing, raw io.Reader, closers ...io.Closer) (*Reader, error) {
+	return newReplicaReader(key, ad, s, []io.Reader{raw}, closers...)
+}
+
+// newReplicaReader returns a Reader that reads each chunk of encrypted
+// data from the first of raws holding an intact copy of it. The Reader
+// holds the key until it is closed.
+func newReplicaReader(key *Secret, ad []byte, s *Signing, raws []io.Reader, closers ...io.Closer) (*Reader, error) {
+	var trailer archive.Trailer
+	if s.signed {
+		trailer = newSignature(s, ad)
+	}
+
+	r, err := archive.NewReplicaReader(raws, key.Bytes(), ad, trailer)
+	if err != nil {
+		key.Close()
+	}
 
 	return &Reader{
-		Reader: r,
-		buffer: buffer,
-		files:  files,
+		Reader:  r,
+		key:     key,
+		closers: closers,
 	}, err
 }
 
-func newArchiveWriter(key []byte, raw io.Writer, files ...File) (*Writer, error) {
-	tagAt, err := files[0].Seek(0, 1)
-	if err != nil {
-		return nil, err
+// newArchiveWriter returns a Writer that holds the key until it is
+// closed.
+func newArchiveWriter(key *Secret, ad []byte, s *Signing, raw io.Writer, closers ...io.Closer) (*Writer, error) {
+	buffer := bufio.NewWriter(raw)
+
+	var trailer archive.Trailer
+	if s.SigningKey != nil {
+		trailer = newSignature(s, ad)
 	}
 
-	buffer := bufio.NewWriter(raw)
-	w, err := archive.NewWriter(buffer, key)
+	w, err := archive.NewWriter(buffer, key.Bytes(), ad, trailer)
+	if err != nil {
+		key.Close()
+	}
 
 	return &Writer{
-		Writer: w,
-		buffer: buffer,
-		files:  files,
-		tagAt:  tagAt,
+		Writer:  w,
+		key:     key,
+		buffer:  buffer,
+		closers: closers,
 	}, err
 }
 
-func verify(key []byte, file File) (bool, error) {
-	p, err := file.Seek(0, 1)
+// writeHeader writes the headers followed by their BLAKE2b-256 digest
+// and returns the digest, which is bound to the encrypted data.
+func writeHeader(w io.Writer, headers ...interface{}) ([]byte, error) {
+	b := &bytes.Buffer{}
+
+	for _, header := range headers {
+		err := binary.Write(b, binary.LE, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sum := blake2b.Sum256(b.Bytes())
+	b.Write(sum[:])
+
+	_, err := w.Write(b.Bytes())
+	return sum[:], err
+}
+
+// readHeader reads the header and returns its serialized bytes.
+func readHeader(r io.Reader, header interface{}) ([]byte, error) {
+	b := &bytes.Buffer{}
+	err := binary.Read(io.TeeReader(r, b), binary.LE, header)
+	return b.Bytes(), err
+}
+
+// readDigest reads the digest following a header and checks that it
+// matches the header. This detects corruption before any expensive key
+// derivation but the digest is not keyed, a header altered along with
+// its digest is only detected when the first chunk fails to decrypt.
+func readDigest(r io.Reader, header []byte) ([]byte, error) {
+	var digest [SumSize]byte
+
+	_, err := io.ReadFull(r, digest[:])
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	sum := blake2b.Sum256(header)
+	if subtle.ConstantTimeCompare(sum[:], digest[:]) != 1 {
+		return nil, ErrCorruptHeader
 	}
-	defer file.Seek(p, 0)
-	buffer := bufio.NewReader(file)
-	return archive.Verify(buffer, key)
+
+	return digest[:], nil
+}
+
+// Damaged returns the shard Files found to be damaged, either with a
+// corrupt header or a damaged copy of the encrypted data.
+func (r *Reader) Damaged() []File {
+	damaged := r.damaged
+	if r.dispersal != nil {
+		return append(damaged, r.dispersal.damaged(r.Reader.Damaged(), r.Reader.Current())...)
+	}
+	for _, i := range r.Reader.Damaged() {
+		if i < len(r.files) {
+			damaged = append(damaged, r.files[i])
+		}
+	}
+	return damaged
 }
 
 func (r *Reader) Close() error {
-	for _, f := range r.files {
-		err := f.Close()
+	defer r.key.Close()
+
+	for _, c := range r.closers {
+		err := c.Close()
 		if err != nil {
 			return err
 		}
@@ -345,7 +691,9 @@ func (r *Reader) Close() error {
 }
 
 func (w *Writer) Close() error {
-	tag, err := w.Finish()
+	defer w.key.Close()
+
+	err := w.Finish()
 	if err != nil {
 		return err
 	}
@@ -355,13 +703,8 @@ func (w *Writer) Close() error {
 		return err
 	}
 
-	for _, f := range w.files {
-		_, err := f.WriteAt(tag, w.tagAt)
-		if err != nil {
-			return err
-		}
-
-		err = f.Close()
+	for _, c := range w.closers {
+		err = c.Close()
 		if err != nil {
 			return err
 		}
@@ -370,10 +713,10 @@ func (w *Writer) Close() error {
 	return nil
 }
 
+// A File is the storage for an archive. Archives are written and read
+// sequentially so a File may be a pipe, socket, or terminal.
 type File interface {
 	io.Reader
 	io.Writer
-	io.WriterAt
-	io.Seeker
 	io.Closer
 }
@@ -3,74 +3,321 @@
 package archive
 
 import (
+	"bufio"
+	"crypto/cipher"
 	"crypto/rand"
-	"crypto/subtle"
+	"encoding/binary"
+	"errors"
 	"io"
 
+	"github.com/wg/ecies"
 	"github.com/wg/ecies/xchacha20poly1305"
 )
 
-const KeySize = xchacha20poly1305.KeySize
+const (
+	KeySize    = xchacha20poly1305.KeySize
+	NonceSize  = xchacha20poly1305.NonceSize
+	TagSize    = xchacha20poly1305.TagSize
+	ChunkSize  = 64 * 1024
+	PrefixSize = NonceSize - 8 - 1
+)
+
+var (
+	ErrVerifyFailed = errors.New("archive: verify failed")
+)
 
+// An Archive encrypts a stream as a sequence of chunks, each sealed
+// with XChaCha20Poly1305 under a nonce made of a random prefix, the
+// chunk counter, and a flag marking the final chunk. Read only returns
+// data from chunks that have been authenticated and reports EOF only
+// after the final chunk, so truncation and reordering are detected.
+// Every chunk is also bound to the associated data, if any.
+//
+// An Archive may be read from several identical copies of the encrypted
+// data. Chunks are read from one copy until a chunk can't be read or
+// authenticated, the copy is then marked as damaged and the chunk read
+// from the next copy instead.
 type Archive struct {
-	xchacha20poly1305.XChaCha20Poly1305
-	tag [xchacha20poly1305.TagSize]byte
-	io.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	nonce   [NonceSize]byte
+	count   uint64
+	chunk   []byte
+	data    []byte
+	err     error
+	trailer Trailer
+	sources []*source
+	current int
+	damaged []int
 	io.Writer
 }
 
-func NewArchiveFromReader(r io.Reader, key []byte) (*Archive, error) {
-	var nonce [xchacha20poly1305.NonceSize]byte
-	a := &Archive{Reader: r}
+// A Trailer authenticates an archive with data following its final
+// chunk, such as a signature. The nonce prefix and every sealed chunk
+// are written to it in order as they are written or authenticated.
+type Trailer interface {
+	io.Writer
+	Size() int
+	Seal() []byte
+	Open(trailer []byte) error
+}
 
-	if _, err := io.ReadFull(r, a.tag[:]); err != nil {
-		return nil, err
-	}
+// A source is one copy of the encrypted data.
+type source struct {
+	*bufio.Reader
+	count   uint64
+	started bool
+}
 
-	if _, err := io.ReadFull(r, nonce[:]); err != nil {
-		return nil, err
+func NewArchiveFromReader(r io.Reader, key, ad []byte) (*Archive, error) {
+	return NewArchiveFromReaders([]io.Reader{r}, key, ad, nil)
+}
+
+// NewArchiveFromReaders returns an Archive that reads from the first of
+// rs that holds an intact copy of each chunk. The Trailer may be nil.
+func NewArchiveFromReaders(rs []io.Reader, key, ad []byte, t Trailer) (*Archive, error) {
+	a := &Archive{ad: ad, trailer: t}
+
+	size := ChunkSize + TagSize + a.trailerSize() + 1
+	for _, r := range rs {
+		s := &source{Reader: bufio.NewReaderSize(r, size)}
+		a.sources = append(a.sources, s)
 	}
 
-	err := a.Init(key, nonce[:])
+	err := a.init(key)
 	return a, err
 }
 
-func NewArchiveForWriter(w io.Writer, key []byte) (*Archive, error) {
-	var nonce [xchacha20poly1305.NonceSize]byte
-	a := &Archive{Writer: w}
+// NewArchiveForWriter returns an Archive that writes to w. The Trailer
+// may be nil.
+func NewArchiveForWriter(w io.Writer, key, ad []byte, t Trailer) (*Archive, error) {
+	a := &Archive{Writer: w, ad: ad, trailer: t}
 
-	if _, err := rand.Read(nonce[:]); err != nil {
+	if _, err := rand.Read(a.nonce[:PrefixSize]); err != nil {
 		return nil, err
 	}
 
-	if err := a.Init(key, nonce[:]); err != nil {
+	if err := a.init(key); err != nil {
 		return nil, err
 	}
 
-	if _, err := w.Write(a.tag[:]); err != nil {
+	if _, err := w.Write(a.nonce[:PrefixSize]); err != nil {
 		return nil, err
 	}
 
-	if _, err := w.Write(nonce[:]); err != nil {
-		return nil, err
+	if t != nil {
+		t.Write(a.nonce[:PrefixSize])
 	}
 
 	return a, nil
 }
 
 func (a *Archive) Read(b []byte) (int, error) {
-	n, err := a.Reader.Read(b)
-	a.Decrypt(b[:n], b[:n])
-	return n, err
+	for len(a.data) == 0 {
+		if a.err != nil {
+			return 0, a.err
+		}
+		a.err = a.open()
+	}
+	n := copy(b, a.data)
+	a.data = a.data[n:]
+	return n, nil
 }
 
 func (a *Archive) Write(b []byte) (int, error) {
-	a.Encrypt(b, b)
-	return a.Writer.Write(b)
+	n := 0
+	for len(b) > 0 {
+		if len(a.data) == ChunkSize {
+			if err := a.seal(false); err != nil {
+				return n, err
+			}
+		}
+
+		m := copy(a.chunk[len(a.data):ChunkSize], b)
+		a.data = a.chunk[:len(a.data)+m]
+		b = b[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close seals and writes the final chunk followed by the trailer.
+func (a *Archive) Close() error {
+	if err := a.seal(true); err != nil {
+		return err
+	}
+
+	if a.trailer != nil {
+		_, err := a.Writer.Write(a.trailer.Seal())
+		return err
+	}
+
+	return nil
+}
+
+// Damaged returns the indexes of the sources found to be damaged.
+func (a *Archive) Damaged() []int {
+	return a.damaged
+}
+
+// Current returns the index of the source the last chunk was read from.
+func (a *Archive) Current() int {
+	return a.current
+}
+
+func (a *Archive) init(key []byte) error {
+	aead, err := ecies.NewXChaCha20Poly1305(key)
+	if err != nil {
+		return err
+	}
+
+	a.aead = aead
+	a.chunk = make([]byte, ChunkSize+TagSize)
+	a.data = a.chunk[:0]
+
+	return nil
+}
+
+// open reads and authenticates the next chunk, falling back to the next
+// source when the chunk can't be read or authenticated. A trailer that
+// fails to authenticate the archive is reported without falling back.
+func (a *Archive) open() error {
+	var err error
+
+	for range a.sources {
+		s := a.sources[a.current]
+
+		var prefix [PrefixSize]byte
+		var sealed, trailer []byte
+		var final bool
+
+		sealed, trailer, final, err = s.next(a.count, prefix[:], a.trailerSize())
+		if err == nil {
+			if a.count == 0 {
+				copy(a.nonce[:PrefixSize], prefix[:])
+			}
+			err = a.unseal(sealed, final)
+		}
+
+		if err != nil {
+			a.damage(a.current)
+			a.current = (a.current + 1) % len(a.sources)
+			continue
+		}
+
+		if a.trailer != nil {
+			if a.count == 0 {
+				a.trailer.Write(a.nonce[:PrefixSize])
+			}
+			a.trailer.Write(sealed)
+		}
+
+		s.Discard(len(sealed))
+		s.count++
+		a.count++
+
+		if !final {
+			return nil
+		}
+
+		if a.trailer != nil {
+			if err := a.trailer.Open(trailer); err != nil {
+				a.data = nil
+				return err
+			}
+		}
+
+		return io.EOF
+	}
+
+	return err
 }
 
-func (a *Archive) Verify() bool {
-	var tag [xchacha20poly1305.TagSize]byte
-	a.Tag(tag[:0])
-	return subtle.ConstantTimeCompare(a.tag[:], tag[:]) == 1
+// damage records source i as damaged.
+func (a *Archive) damage(i int) {
+	for _, j := range a.damaged {
+		if i == j {
+			return
+		}
+	}
+	a.damaged = append(a.damaged, i)
+}
+
+func (a *Archive) unseal(sealed []byte, final bool) error {
+	chunk := a.chunk[:copy(a.chunk, sealed)]
+	data, err := a.aead.Open(chunk, a.next(final), chunk, a.ad)
+	if err != nil {
+		return ErrVerifyFailed
+	}
+	a.data = data
+	return nil
+}
+
+func (a *Archive) seal(final bool) error {
+	sealed := a.aead.Seal(a.chunk, a.next(final), a.data, a.ad)
+	a.data = a.chunk[:0]
+	a.count++
+
+	if a.trailer != nil {
+		a.trailer.Write(sealed)
+	}
+
+	_, err := a.Writer.Write(sealed)
+	return err
+}
+
+func (a *Archive) next(final bool) []byte {
+	binary.BigEndian.PutUint64(a.nonce[PrefixSize:], a.count)
+	a.nonce[NonceSize-1] = 0
+	if final {
+		a.nonce[NonceSize-1] = 1
+	}
+	return a.nonce[:]
+}
+
+func (a *Archive) trailerSize() int {
+	if a.trailer == nil {
+		return 0
+	}
+	return a.trailer.Size()
+}
+
+// next returns sealed chunk i and whether it is the final chunk, in
+// which case the trailer following it is also returned. The nonce
+// prefix is read into prefix if the source has not been read before.
+func (s *source) next(i uint64, prefix []byte, trailer int) ([]byte, []byte, bool, error) {
+	if !s.started {
+		if _, err := io.ReadFull(s, prefix); err != nil {
+			return nil, nil, false, short(err)
+		}
+		s.started = true
+	}
+
+	for ; s.count < i; s.count++ {
+		if _, err := s.Discard(ChunkSize + TagSize); err != nil {
+			return nil, nil, false, short(err)
+		}
+	}
+
+	b, err := s.Peek(ChunkSize + TagSize + trailer + 1)
+	switch {
+	case err == nil:
+		return b[:ChunkSize+TagSize], nil, false, nil
+	case err != io.EOF:
+		return nil, nil, false, err
+	case len(b) < TagSize+trailer:
+		return nil, nil, false, ErrVerifyFailed
+	}
+
+	n := len(b) - trailer
+	return b[:n], b[n:], true, nil
+}
+
+// short returns ErrVerifyFailed in place of the error from reading
+// fewer bytes than expected.
+func short(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrVerifyFailed
+	}
+	return err
 }
@@ -24,7 +24,7 @@ func TestCreateArchive(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	r, err := NewReader(buf, key)
+	r, err := NewReader(buf, key, header)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,7 +66,7 @@ func TestVerifyArchive(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if valid, _ := Verify(buf, key); !valid {
+	if valid, _ := Verify(buf, key, header); !valid {
 		t.Fatal("archive verify failed")
 	}
 }
@@ -85,11 +85,27 @@ func TestVerifyFailWrongKey(t *testing.T) {
 
 	key[0] = ^key[0]
 
-	if valid, _ := Verify(buf, key); valid {
+	if valid, _ := Verify(buf, key, header); valid {
 		t.Fatal("verified invalid archive")
 	}
 }
 
+func TestVerifyFailWrongHeader(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "foo", Size: 32},
+	}
+	key := randomKey()
+
+	buf, _, err := createArchive(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if valid, _ := Verify(buf, key, []byte("redaeh")); valid {
+		t.Fatal("verified archive with wrong header")
+	}
+}
+
 func TestVerifyFailByteFlip(t *testing.T) {
 	entries := []*tar.Header{
 		{Name: "foo", Size: 32},
@@ -107,7 +123,7 @@ func TestVerifyFailByteFlip(t *testing.T) {
 		archive[i] = ^archive[i]
 
 		r := bytes.NewReader(archive)
-		if valid, _ := Verify(r, key); valid {
+		if valid, _ := Verify(r, key, header); valid {
 			t.Fatal("verified invalid archive at", i)
 		}
 
@@ -115,6 +131,133 @@ func TestVerifyFailByteFlip(t *testing.T) {
 	}
 }
 
+func TestVerifyFailTruncated(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "foo", Size: 4 * ChunkSize},
+	}
+	key := randomKey()
+
+	buf, _, err := createArchive(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := buf.Bytes()
+	chunk := ChunkSize + TagSize
+
+	for n := PrefixSize + chunk; n < len(archive); n += chunk {
+		r := bytes.NewReader(archive[:n])
+		if valid, _ := Verify(r, key, header); valid {
+			t.Fatal("verified archive truncated at", n)
+		}
+	}
+}
+
+func TestReadOnlyAuthenticated(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "foo", Size: 4 * ChunkSize},
+	}
+	key := randomKey()
+
+	buf, _, err := createArchive(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := buf.Bytes()
+	archive[PrefixSize+ChunkSize+TagSize+1] ^= 0xff
+
+	a, err := NewArchiveFromReader(bytes.NewReader(archive), key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch n, err := io.Copy(ioutil.Discard, a); {
+	case err != ErrVerifyFailed:
+		t.Fatal("expected verify failure got", err)
+	case n != ChunkSize:
+		t.Fatalf("expected to read %d bytes got %d", ChunkSize, n)
+	}
+}
+
+func TestReplicaReader(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "foo", Size: 4 * ChunkSize},
+	}
+	key := randomKey()
+
+	buf, dat, err := createArchive(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := ChunkSize + TagSize
+	copies := make([][]byte, 3)
+	readers := make([]io.Reader, len(copies))
+	for i := range copies {
+		copies[i] = append([]byte{}, buf.Bytes()...)
+		readers[i] = bytes.NewReader(copies[i])
+	}
+
+	copies[0][PrefixSize+chunk+1] ^= 0xff
+	copies[1][PrefixSize+3*chunk+1] ^= 0xff
+	copies[2] = copies[2][:PrefixSize+chunk]
+	readers[2] = bytes.NewReader(copies[2])
+
+	r, err := NewReplicaReader(readers, key, header, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	switch b, err := ioutil.ReadAll(r); {
+	case err != nil:
+		t.Fatal(err)
+	case !bytes.Equal(b, dat[0]):
+		t.Fatal("read incorrect data from replicas")
+	}
+
+	if !r.Verify() {
+		t.Fatal("archive verify failed")
+	}
+
+	if damaged := r.Damaged(); len(damaged) != 3 {
+		t.Fatal("expected 3 damaged replicas got", damaged)
+	}
+}
+
+func TestReplicasDamaged(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "foo", Size: 4 * ChunkSize},
+	}
+	key := randomKey()
+
+	buf, _, err := createArchive(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := ChunkSize + TagSize
+	readers := make([]io.Reader, 2)
+	for i := range readers {
+		archive := append([]byte{}, buf.Bytes()...)
+		archive[PrefixSize+chunk+1] ^= 0xff
+		readers[i] = bytes.NewReader(archive)
+	}
+
+	a, err := NewArchiveFromReaders(readers, key, header, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.Copy(ioutil.Discard, a); err != ErrVerifyFailed {
+		t.Fatal("expected verify failure got", err)
+	}
+}
+
 func TestWriterInvariants(t *testing.T) {
 	_, _, err := createArchive(make([]byte, 31), nil)
 	if err == nil {
@@ -125,7 +268,7 @@ func TestWriterInvariants(t *testing.T) {
 func createArchive(key []byte, entries []*tar.Header) (*Buffer, [][]byte, error) {
 	buf := &Buffer{}
 
-	arc, err := NewWriter(buf, key)
+	arc, err := NewWriter(buf, key, header, nil)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -150,12 +293,15 @@ func createArchive(key []byte, entries []*tar.Header) (*Buffer, [][]byte, error)
 		}
 	}
 
-	tag, _ := arc.Finish()
-	copy(buf.Bytes()[0:16], tag)
+	if err := arc.Finish(); err != nil {
+		return nil, nil, err
+	}
 
 	return buf, dat, nil
 }
 
+var header = []byte("header")
+
 type Buffer struct {
 	bytes.Buffer
 }
@@ -168,3 +314,73 @@ func randomKey() []byte {
 	}
 	return key
 }
+
+func TestSparseFile(t *testing.T) {
+	data := make([]byte, 3<<16)
+	rand.Read(data[100:612])
+	rand.Read(data[1<<16 : 1<<17])
+
+	regions := []Region{{100, 512}, {1 << 16, 1 << 16}, {3 << 16, 0}}
+	for i := range data {
+		if data[i] != 0 && (i < 100 || i >= 612 && i < 1<<16 || i >= 1<<17) {
+			t.Fatal("test data outside regions")
+		}
+	}
+
+	key := randomKey()
+	buf := &Buffer{}
+
+	arc, err := NewWriter(buf, key, header, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []*tar.Header{
+		{Name: "dir/sparse", Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data)), Uname: "alice"},
+		{Name: "after", Typeflag: tar.TypeReg, Mode: 0600},
+	}
+
+	if err := arc.AddSparse(entries[0], regions, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arc.Add(entries[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arc.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(buf, key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch next, err := r.Next(); {
+	case err != nil:
+		t.Fatal(err)
+	case next.Name != "dir/sparse" || next.Size != int64(len(data)) || next.Uname != "alice":
+		t.Fatalf("sparse entry header %+v", next)
+	case !Sparse(next):
+		t.Fatal("sparse entry not sparse")
+	}
+
+	switch b, err := ioutil.ReadAll(r); {
+	case err != nil:
+		t.Fatal(err)
+	case !bytes.Equal(b, data):
+		t.Fatal("sparse entry content mismatch")
+	}
+
+	switch next, err := r.Next(); {
+	case err != nil:
+		t.Fatal(err)
+	case next.Name != "after" || Sparse(next):
+		t.Fatal("entry after sparse entry", next.Name)
+	}
+
+	if !r.Verify() {
+		t.Fatal("archive verify failed")
+	}
+}
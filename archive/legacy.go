@@ -0,0 +1,98 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/subtle"
+	"io"
+	"io/ioutil"
+
+	"github.com/wg/ecies/xchacha20poly1305"
+)
+
+// A legacy archive is the format arc wrote before archives were split
+// into chunks: a Poly1305 tag and nonce followed by the data encrypted
+// as a single XChaCha20 stream and authenticated only as a whole.
+type legacy struct {
+	xchacha20poly1305.XChaCha20Poly1305
+	tag [TagSize]byte
+	io.Reader
+}
+
+// NewLegacyReader returns a Reader for a legacy archive. The whole
+// archive is authenticated before any of it is released so r is read
+// twice, from its current offset, and must be seekable.
+func NewLegacyReader(r io.ReadSeeker, key []byte) (*Reader, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := newLegacy(bufio.NewReader(r), key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = io.Copy(ioutil.Discard, l); err != nil {
+		return nil, err
+	}
+
+	var tag [TagSize]byte
+	l.Tag(tag[:0])
+	if subtle.ConstantTimeCompare(l.tag[:], tag[:]) != 1 {
+		return nil, ErrVerifyFailed
+	}
+
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if l, err = newLegacy(bufio.NewReader(r), key); err != nil {
+		return nil, err
+	}
+
+	compressor, err := gzip.NewReader(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		archiver:   tar.NewReader(compressor),
+		compressor: compressor,
+		archive:    l,
+	}, nil
+}
+
+func newLegacy(r io.Reader, key []byte) (*legacy, error) {
+	var nonce [NonceSize]byte
+	l := &legacy{Reader: r}
+
+	if _, err := io.ReadFull(r, l.tag[:]); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r, nonce[:]); err != nil {
+		return nil, err
+	}
+
+	return l, l.Init(key, nonce[:])
+}
+
+func (l *legacy) Read(b []byte) (int, error) {
+	n, err := l.Reader.Read(b)
+	l.Decrypt(b[:n], b[:n])
+	return n, err
+}
+
+// Damaged returns nil as a legacy archive has a single copy.
+func (l *legacy) Damaged() []int {
+	return nil
+}
+
+// Current returns 0 as a legacy archive has a single copy.
+func (l *legacy) Current() int {
+	return 0
+}
@@ -6,16 +6,30 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"io"
+	"io/ioutil"
 )
 
 type Reader struct {
 	archiver   *tar.Reader
 	compressor *gzip.Reader
-	archive    *Archive
+	archive    stream
 }
 
-func NewReader(r io.Reader, key []byte) (*Reader, error) {
-	archive, err := NewArchiveFromReader(r, key)
+// A stream is the decrypted data of an archive.
+type stream interface {
+	io.Reader
+	Damaged() []int
+	Current() int
+}
+
+func NewReader(r io.Reader, key, ad []byte) (*Reader, error) {
+	return NewReplicaReader([]io.Reader{r}, key, ad, nil)
+}
+
+// NewReplicaReader returns a Reader that reads each chunk from the first
+// of rs holding an intact copy of it. The Trailer may be nil.
+func NewReplicaReader(rs []io.Reader, key, ad []byte, t Trailer) (*Reader, error) {
+	archive, err := NewArchiveFromReaders(rs, key, ad, t)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +56,21 @@ func (r *Reader) Read(b []byte) (int, error) {
 	return r.archiver.Read(b)
 }
 
+// Damaged returns the indexes of the readers found to hold a damaged
+// copy of the archive.
+func (r *Reader) Damaged() []int {
+	return r.archive.Damaged()
+}
+
+// Current returns the index of the reader the last chunk was read from.
+func (r *Reader) Current() int {
+	return r.archive.Current()
+}
+
+// Verify consumes any data remaining after the end of the tar stream
+// and reports whether the archive was authenticated through to its
+// final chunk.
 func (r *Reader) Verify() bool {
-	return r.archive.Verify()
+	_, err := io.Copy(ioutil.Discard, r.archive)
+	return err == nil
 }
@@ -0,0 +1,171 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// archive/tar reads sparse files but does not write them, and drops the
+// GNU.sparse PAX records that describe them, so AddSparse encodes the
+// PAX extended header and ustar header of a GNU PAX 1.0 sparse file
+// itself. The entry's data begins with a map of the data regions, as
+// decimal lines padded to a block, followed by the data of each region.
+
+const (
+	blockSize = 512
+)
+
+// A Region is a range of a sparse file holding data rather than a hole.
+type Region struct {
+	Offset int64
+	Length int64
+}
+
+// Sparse reports whether the header read from an archive is for a file
+// stored in one of the GNU sparse formats.
+func Sparse(header *tar.Header) bool {
+	_, pax := header.PAXRecords["GNU.sparse.major"]
+	_, old := header.PAXRecords["GNU.sparse.map"]
+	return header.Typeflag == tar.TypeGNUSparse || pax || old
+}
+
+// AddSparse adds a regular file of header.Size bytes holding only the
+// data regions read from r, which are in order and do not overlap.
+func (w *Writer) AddSparse(header *tar.Header, regions []Region, r io.ReaderAt) error {
+	if err := w.archiver.Flush(); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%d\n", len(regions))
+	for _, region := range regions {
+		fmt.Fprintf(buf, "%d\n%d\n", region.Offset, region.Length)
+	}
+	buf.Write(make([]byte, padding(int64(buf.Len()))))
+
+	size := int64(buf.Len())
+	for _, region := range regions {
+		size += region.Length
+	}
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     header.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(header.Size, 10),
+		"size":                strconv.FormatInt(size, 10),
+		"mtime":               strconv.FormatInt(header.ModTime.Unix(), 10),
+		"uid":                 strconv.Itoa(header.Uid),
+		"gid":                 strconv.Itoa(header.Gid),
+	}
+
+	if header.Uname != "" {
+		records["uname"] = header.Uname
+	}
+	if header.Gname != "" {
+		records["gname"] = header.Gname
+	}
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+
+	dir, file := path.Split(header.Name)
+	pax := paxRecords(records)
+
+	blocks := [][]byte{
+		ustar(path.Join(dir, "PaxHeaders.0", file), tar.TypeXHeader, int64(len(pax)), header),
+		pad(pax),
+		ustar(path.Join(dir, "GNUSparseFile.0", file), tar.TypeReg, size, header),
+		buf.Bytes(),
+	}
+
+	for _, b := range blocks {
+		if _, err := w.compressor.Write(b); err != nil {
+			return err
+		}
+	}
+
+	for _, region := range regions {
+		section := io.NewSectionReader(r, region.Offset, region.Length)
+		switch n, err := io.Copy(w.compressor, section); {
+		case err != nil:
+			return err
+		case n < region.Length:
+			return ErrShortCopy
+		}
+	}
+
+	_, err := w.compressor.Write(make([]byte, padding(size)))
+	return err
+}
+
+// ustar returns a ustar header block. Fields too long for the block are
+// truncated, as the PAX records written with it hold their full values.
+func ustar(name string, flag byte, size int64, h *tar.Header) []byte {
+	b := make([]byte, blockSize)
+
+	octal := func(field []byte, n int64) {
+		if s := strconv.FormatInt(n, 8); n >= 0 && len(s) < len(field) {
+			copy(field, strings.Repeat("0", len(field)-1-len(s))+s)
+		}
+	}
+
+	copy(b[0:100], name)
+	octal(b[100:108], h.Mode&07777)
+	octal(b[108:116], int64(h.Uid))
+	octal(b[116:124], int64(h.Gid))
+	octal(b[124:136], size)
+	octal(b[136:148], h.ModTime.Unix())
+	b[156] = flag
+	copy(b[257:265], "ustar\x0000")
+	copy(b[265:297], h.Uname)
+	copy(b[297:329], h.Gname)
+
+	copy(b[148:156], "        ")
+	sum := int64(0)
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return b
+}
+
+// paxRecords returns the records formatted as PAX extended header data,
+// with each record prefixed by its own length in decimal.
+func paxRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	for _, k := range keys {
+		size := len(k) + len(records[k]) + 3
+		size += len(strconv.Itoa(size))
+		record := fmt.Sprintf("%d %s=%s\n", size, k, records[k])
+		if len(record) != size {
+			record = fmt.Sprintf("%d %s=%s\n", len(record), k, records[k])
+		}
+		buf.WriteString(record)
+	}
+
+	return buf.Bytes()
+}
+
+func pad(b []byte) []byte {
+	return append(b, make([]byte, padding(int64(len(b))))...)
+}
+
+func padding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
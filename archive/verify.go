@@ -7,16 +7,18 @@ import (
 	"io/ioutil"
 )
 
-func Verify(r io.Reader, key []byte) (bool, error) {
-	archive, err := NewArchiveFromReader(r, key)
+func Verify(r io.Reader, key, ad []byte) (bool, error) {
+	archive, err := NewArchiveFromReader(r, key, ad)
 	if err != nil {
 		return false, err
 	}
 
-	_, err = io.Copy(ioutil.Discard, archive)
-	if err != nil {
+	switch _, err = io.Copy(ioutil.Discard, archive); {
+	case err == ErrVerifyFailed:
+		return false, nil
+	case err != nil:
 		return false, err
 	}
 
-	return archive.Verify(), nil
+	return true, nil
 }
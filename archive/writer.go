@@ -20,8 +20,10 @@ type Writer struct {
 	archive    *Archive
 }
 
-func NewWriter(w io.Writer, key []byte) (*Writer, error) {
-	archive, err := NewArchiveForWriter(w, key)
+// NewWriter returns a Writer that writes an archive to w followed by
+// the Trailer, which may be nil.
+func NewWriter(w io.Writer, key, ad []byte, t Trailer) (*Writer, error) {
+	archive, err := NewArchiveForWriter(w, key, ad, t)
 	if err != nil {
 		return nil, err
 	}
@@ -50,14 +52,14 @@ func (w *Writer) Copy(r io.Reader, size int64) error {
 	return w.archiver.Flush()
 }
 
-func (w *Writer) Finish() ([]byte, error) {
+func (w *Writer) Finish() error {
 	if err := w.archiver.Close(); err != nil {
-		return nil, err
+		return err
 	}
 
 	if err := w.compressor.Close(); err != nil {
-		return nil, err
+		return err
 	}
 
-	return w.archive.Tag(nil), nil
+	return w.archive.Close()
 }
@@ -12,10 +12,15 @@ import (
 	"testing"
 
 	"github.com/codahale/sss"
-	"github.com/magical/argon2"
+	"github.com/dchest/blake2b"
 	"github.com/wg/arc/archive"
+	"github.com/wg/arc/argon2"
+	"github.com/wg/arc/ida"
 )
 
+// shardData is the offset of the encrypted data in a shard.
+const shardData = 3 + SetSize + 3 + KeySize + SumSize
+
 var entries = []*tar.Header{
 	{Name: "foo", Size: 0},
 	{Name: "bar", Size: 1<<16 - 1},
@@ -23,63 +28,143 @@ var entries = []*tar.Header{
 }
 
 func TestPasswordArchive(t *testing.T) {
-	arc := NewPasswordArchive([]byte("secret"), 1, 8, &Buffer{})
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
 	dat := createArchive(t, arc)
 	verifyArchive(t, arc, dat)
 }
 
 func TestPasswordArchiveKey(t *testing.T) {
 	var (
-		password   = []byte("secret")
-		iterations = 1
-		memory     = 8
+		password   = secret("secret")
+		iterations = uint32(1)
+		memory     = uint32(16)
+		lanes      = uint8(2)
 	)
 
 	buf := &Buffer{}
-	arc := NewPasswordArchive(password, uint32(iterations), uint32(memory), buf)
+	arc := NewPasswordArchive(password, iterations, memory, lanes, buf)
 	createArchive(t, arc)
 
 	buf.Rewind()
-	buf.Seek(2+4+4+32, 0)
+	buf.Seek(3+2+4+4+32, 0)
+	ad := digest(t, buf)
 
-	key, err := argon2.Key(password, arc.Salt[:], int(iterations), 1, int64(memory), KeySize)
+	key, err := argon2.Key(password.Bytes(), arc.Salt[:], argon2.Argon2id, iterations, memory, lanes, KeySize)
 	if err != nil {
 		t.Fatal("password key derivation failed", err)
 	}
 
-	if valid, err := archive.Verify(buf, key); !valid || err != nil {
+	if valid, err := archive.Verify(buf, key, ad); !valid || err != nil {
 		t.Fatal("password archive key incorrect")
 	}
 }
 
 func TestPasswordArchiveFormat(t *testing.T) {
 	buf := &Buffer{}
-	arc := NewPasswordArchive([]byte("secret"), 2, 16, buf)
+	arc := NewPasswordArchive(secret("secret"), 2, 16, 1, buf)
 	createArchive(t, arc)
 
-	if binary.LittleEndian.Uint32(buf.buffer[2:6]) != arc.Iterations {
+	if buf.buffer[3] != Argon2id || buf.buffer[4] != arc.Lanes {
+		t.Fatal("serialized variant or lanes incorrect")
+	}
+
+	if binary.LittleEndian.Uint32(buf.buffer[5:9]) != arc.Iterations {
 		t.Fatal("serialized iterations incorrect")
 	}
 
-	if binary.LittleEndian.Uint32(buf.buffer[6:10]) != arc.Memory {
+	if binary.LittleEndian.Uint32(buf.buffer[9:13]) != arc.Memory {
 		t.Fatal("serialized memory incorrect")
 	}
 
-	if !bytes.Equal(buf.buffer[10:42], arc.Salt[:]) {
+	if !bytes.Equal(buf.buffer[13:45], arc.Salt[:]) {
 		t.Fatal("serialized salt incorrect")
 	}
 }
 
 func TestWrongPassword(t *testing.T) {
-	arc := NewPasswordArchive([]byte("secret"), 1, 8, &Buffer{})
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
 	createArchive(t, arc)
-	arc.Password = []byte("terces")
+	arc.Password = secret("terces")
 	ensureInvalid(t, arc)
 }
 
+func TestCostLimits(t *testing.T) {
+	defer func(limits CostLimits) { Limits = limits }(Limits)
+
+	arc := NewPasswordArchive(secret("secret"), 2, 16, 1, &Buffer{})
+	createArchive(t, arc)
+
+	buf := &Buffer{}
+	slots := NewKeySlotArchive(slotKeys(secret("secret"), nil), nil, nil, buf)
+	slots.Keys[0].Iterations = 2
+	slots.Keys[0].Memory = 16
+	createArchive(t, slots)
+
+	for _, limits := range []CostLimits{{1, 16}, {2, 8}} {
+		Limits = limits
+
+		rewind(arc)
+		if _, err := arc.Reader(); !costError(err, 2, 16) {
+			t.Fatal("expected cost error got", err)
+		}
+
+		buf.Rewind()
+		_, err := NewKeySlotArchive(nil, secret("secret"), nil, buf).Reader()
+		if !costError(err, 2, 16) {
+			t.Fatal("expected cost error got", err)
+		}
+	}
+
+	Limits = CostLimits{2, 16}
+	rewind(arc)
+	if _, err := arc.Reader(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func costError(err error, iterations, memory uint32) bool {
+	e, ok := err.(*CostError)
+	return ok && e.Iterations == iterations && e.Memory == memory
+}
+
+func TestStreamArchive(t *testing.T) {
+	r, w := io.Pipe()
+	dat := make(chan [][]byte, 1)
+
+	go func() {
+		arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Pipe{Writer: w, Closer: w})
+		dat <- createArchive(t, arc)
+	}()
+
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Pipe{Reader: r, Closer: r})
+	reader, err := arc.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entries {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+
+		switch b, err := ioutil.ReadAll(reader); {
+		case err != nil:
+			t.Fatal(err)
+		case int(e.Size) != len(b):
+			t.Fatalf("expected to read %d bytes got %d", e.Size, len(b))
+		}
+	}
+
+	if !reader.Verify() {
+		t.Fatalf("archive verify failed")
+	}
+
+	<-dat
+}
+
 func TestCurve448Archive(t *testing.T) {
 	public, private := keypair(t)
-	arc := NewCurve448Archive(public, private, &Buffer{})
+	arc := NewCurve448Archive([]*PublicKey{public}, private, &Buffer{})
 	dat := createArchive(t, arc)
 	verifyArchive(t, arc, dat)
 }
@@ -87,18 +172,24 @@ func TestCurve448Archive(t *testing.T) {
 func TestCurve448ArchiveKey(t *testing.T) {
 	public, private := keypair(t)
 	buf := &Buffer{}
-	arc := NewCurve448Archive(public, nil, buf)
+	arc := NewCurve448Archive([]*PublicKey{public}, nil, buf)
 	createArchive(t, arc)
 
 	buf.Rewind()
-	buf.Seek(2+56, 0)
+	buf.Seek(4+56+72, 0)
+	ad := digest(t, buf)
 
-	key, err := ComputeSharedKey(&arc.Ephemeral, private, KeySize)
+	shared, err := ComputeSharedKey(&arc.Ephemeral, private, KeySize)
 	if err != nil {
 		t.Fatal("curve448 key derivation failed", err)
 	}
 
-	if valid, err := archive.Verify(buf, key); !valid || err != nil {
+	key, ok := arc.Recipients[0].Open(shared)
+	if !ok {
+		t.Fatal("curve448 recipient key incorrect")
+	}
+
+	if valid, err := archive.Verify(buf, key.Bytes(), ad); !valid || err != nil {
 		t.Fatal("curve448 archive key incorrect")
 	}
 }
@@ -106,20 +197,48 @@ func TestCurve448ArchiveKey(t *testing.T) {
 func TestCurve448ArchiveFormat(t *testing.T) {
 	public, private := keypair(t)
 	buf := &Buffer{}
-	arc := NewCurve448Archive(public, private, buf)
+	arc := NewCurve448Archive([]*PublicKey{public}, private, buf)
 	createArchive(t, arc)
 
-	if !bytes.Equal(buf.buffer[2:58], arc.Ephemeral[:]) {
+	if buf.buffer[3] != 1 {
+		t.Fatal("serialized recipient count incorrect")
+	}
+
+	if !bytes.Equal(buf.buffer[4:60], arc.Ephemeral[:]) {
 		t.Fatal("serialized ephemeral public key incorrect")
 	}
+
+	r := arc.Recipients[0]
+	if !bytes.Equal(buf.buffer[60:132], append(append(r.Tag[:], r.Nonce[:]...), r.Key[:]...)) {
+		t.Fatal("serialized recipient incorrect")
+	}
 }
 
 func TestWrongPrivateKey(t *testing.T) {
 	public, _ := keypair(t)
 	_, private := keypair(t)
-	arc := NewCurve448Archive(public, private, &Buffer{})
+	arc := NewCurve448Archive([]*PublicKey{public}, private, &Buffer{})
 	createArchive(t, arc)
-	ensureInvalid(t, arc)
+
+	if _, err := arc.Reader(); err != ErrNotRecipient {
+		t.Fatal("expected not recipient got", err)
+	}
+}
+
+func TestMultipleRecipients(t *testing.T) {
+	public0, private0 := keypair(t)
+	public1, private1 := keypair(t)
+	public2, private2 := keypair(t)
+
+	buf := &Buffer{}
+	public := []*PublicKey{public0, public1, public2}
+	arc := NewCurve448Archive(public, nil, buf)
+	dat := createArchive(t, arc)
+
+	for _, private := range []*PrivateKey{private0, private1, private2} {
+		verifyArchive(t, NewCurve448Archive(nil, private, buf), dat)
+		buf.Rewind()
+	}
 }
 
 func TestShardArchive(t *testing.T) {
@@ -141,9 +260,9 @@ func TestShardArchiveKey(t *testing.T) {
 	for _, shard := range arc.Shards {
 		buf := shard.File.(*Buffer)
 		buf.Rewind()
-		buf.Seek(2+1+KeySize, 0)
+		buf.Seek(shardData, 0)
 
-		if valid, err := archive.Verify(buf, key); !valid || err != nil {
+		if valid, err := archive.Verify(buf, key, arc.Digest()); !valid || err != nil {
 			t.Fatal("shard archive key incorrect")
 		}
 	}
@@ -156,11 +275,21 @@ func TestShardArchiveFormat(t *testing.T) {
 	for _, shard := range arc.Shards {
 		buf := shard.File.(*Buffer)
 
-		if buf.buffer[2] != shard.ID {
+		if !bytes.Equal(buf.buffer[3:3+SetSize], arc.Set[:]) {
+			t.Fatal("serialized shard set incorrect")
+		}
+
+		offset := 3 + SetSize
+		if buf.buffer[offset] != shard.ID {
 			t.Fatal("serialized shard ID incorrect")
 		}
 
-		if !bytes.Equal(buf.buffer[3:3+KeySize], shard.Share[:]) {
+		if buf.buffer[offset+1] != 2 || buf.buffer[offset+2] != 3 {
+			t.Fatal("serialized shard threshold or count incorrect")
+		}
+
+		offset += 3
+		if !bytes.Equal(buf.buffer[offset:offset+KeySize], shard.Share[:]) {
 			t.Fatal("serialized shard share incorrect")
 		}
 	}
@@ -170,14 +299,144 @@ func TestMissingShard(t *testing.T) {
 	arc := NewShardArchive(2, buffers(2))
 	createArchive(t, arc)
 	arc.Shards = arc.Shards[:1]
+	ensureMissing(t, arc, 1)
+}
+
+func TestDuplicateShard(t *testing.T) {
+	arc := NewShardArchive(2, buffers(3))
+	createArchive(t, arc)
+	buf := *arc.Shards[0].File.(*Buffer)
+	arc.Shards[1].File = &buf
+	arc.Shards = arc.Shards[:2]
+	ensureMissing(t, arc, 1)
+}
+
+func TestMixedShards(t *testing.T) {
+	arc0 := NewShardArchive(2, buffers(3))
+	createArchive(t, arc0)
+	arc1 := NewShardArchive(2, buffers(3))
+	createArchive(t, arc1)
+
+	arc0.Shards[1] = arc1.Shards[1]
+
+	if _, err := arc0.Reader(); err != ErrMixedShards {
+		t.Fatal("expected mixed shards got", err)
+	}
+}
+
+func TestDamagedShard(t *testing.T) {
+	arc := NewShardArchive(2, buffers(3))
+	dat := createArchive(t, arc)
+
+	files := arc.Files()
+	files[0].(*Buffer).buffer[shardData+archive.PrefixSize] ^= 0xff
+
+	reader := verifyArchive(t, arc, dat)
+	ensureDamaged(t, reader, files[0])
+}
+
+func TestDamagedShards(t *testing.T) {
+	arc := NewShardArchive(2, buffers(3))
+	dat := createArchive(t, arc)
+
+	chunk := archive.ChunkSize + archive.TagSize
+	files := arc.Files()
+	files[0].(*Buffer).buffer[shardData+archive.PrefixSize] ^= 0xff
+	files[1].(*Buffer).buffer[shardData+archive.PrefixSize+chunk] ^= 0xff
+
+	reader := verifyArchive(t, arc, dat)
+	ensureDamaged(t, reader, files[0], files[1])
+}
+
+func TestCorruptShardHeader(t *testing.T) {
+	arc := NewShardArchive(2, buffers(3))
+	dat := createArchive(t, arc)
+
+	files := arc.Files()
+	files[1].(*Buffer).buffer[5] ^= 0xff
+
+	reader := verifyArchive(t, arc, dat)
+	ensureDamaged(t, reader, files[1])
+}
+
+func TestAllShardsDamaged(t *testing.T) {
+	arc := NewShardArchive(2, buffers(2))
+	createArchive(t, arc)
+
+	for _, file := range arc.Files() {
+		file.(*Buffer).buffer[shardData+archive.PrefixSize] ^= 0xff
+	}
+
 	ensureInvalid(t, arc)
 }
 
+func TestDispersedShardArchive(t *testing.T) {
+	files := buffers(3)
+	arc := NewDispersedShardArchive(2, files)
+	dat := createArchive(t, arc)
+	verifyArchive(t, arc, dat)
+
+	for _, subset := range [][]File{{files[0], files[1]}, {files[2], files[0]}, {files[1], files[2]}} {
+		rewind(arc)
+		verifyArchive(t, NewShardArchive(2, subset), dat)
+	}
+}
+
+func TestDispersedShardArchiveSize(t *testing.T) {
+	replicated := NewShardArchive(3, buffers(5))
+	dispersed := NewDispersedShardArchive(3, buffers(5))
+
+	createArchive(t, replicated)
+	createArchive(t, dispersed)
+
+	for i, shard := range dispersed.Shards {
+		full := len(replicated.Shards[i].File.(*Buffer).buffer)
+		size := len(shard.File.(*Buffer).buffer)
+		if size > full/3+ida.StripeSize {
+			t.Fatalf("dispersed shard is %d bytes, replicated %d", size, full)
+		}
+	}
+}
+
+func TestMissingDispersedShard(t *testing.T) {
+	arc := NewDispersedShardArchive(2, buffers(3))
+	createArchive(t, arc)
+	arc.Shards = arc.Shards[:1]
+	ensureMissing(t, arc, 1)
+}
+
+func TestDamagedDispersedShard(t *testing.T) {
+	for _, n := range []int{3, 5} {
+		k := n/2 + 1
+		for i := 0; i < k; i++ {
+			arc := NewDispersedShardArchive(k, buffers(n))
+			dat := createArchive(t, arc)
+
+			files := arc.Files()
+			files[i].(*Buffer).buffer[shardData+ida.StripeSize/2] ^= 0xff
+
+			reader := verifyArchive(t, arc, dat)
+			ensureDamaged(t, reader, files[i])
+		}
+	}
+}
+
+func TestDamagedDispersedShards(t *testing.T) {
+	arc := NewDispersedShardArchive(3, buffers(5))
+	dat := createArchive(t, arc)
+
+	files := arc.Files()
+	files[0].(*Buffer).buffer[shardData+ida.StripeSize/2] ^= 0xff
+	files[2].(*Buffer).buffer[shardData+ida.StripeSize/2] ^= 0xff
+
+	verifyArchive(t, arc, dat)
+}
+
 func TestArchiveHeader(t *testing.T) {
 	public, private := keypair(t)
 	var (
-		password = NewPasswordArchive([]byte("secret"), 1, 8, &Buffer{})
-		curve448 = NewCurve448Archive(public, private, &Buffer{})
+		password = NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+		curve448 = NewCurve448Archive([]*PublicKey{public}, private, &Buffer{})
 		shard    = NewShardArchive(2, buffers(2))
 	)
 
@@ -207,11 +466,50 @@ func TestArchiveHeader(t *testing.T) {
 	}
 }
 
+func TestHeaderDigest(t *testing.T) {
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+	createArchive(t, arc)
+
+	sum := blake2b.Sum256(buf.buffer[:45])
+	if !bytes.Equal(buf.buffer[45:45+SumSize], sum[:]) {
+		t.Fatal("serialized header digest incorrect")
+	}
+}
+
+func TestCorruptHeader(t *testing.T) {
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+	createArchive(t, arc)
+
+	buf.buffer[11] ^= 0xff
+
+	if _, err := arc.Reader(); err != ErrCorruptHeader {
+		t.Fatal("expected corrupt header got", err)
+	}
+}
+
+func TestTamperedHeader(t *testing.T) {
+	public0, private := keypair(t)
+	public1, _ := keypair(t)
+	buf := &Buffer{}
+	arc := NewCurve448Archive([]*PublicKey{public0, public1}, private, buf)
+	createArchive(t, arc)
+
+	r0 := append([]byte{}, buf.buffer[60:132]...)
+	copy(buf.buffer[60:132], buf.buffer[132:204])
+	copy(buf.buffer[132:204], r0)
+	sum := blake2b.Sum256(buf.buffer[:204])
+	copy(buf.buffer[204:], sum[:])
+
+	ensureInvalid(t, arc)
+}
+
 func TestWrongArchiveType(t *testing.T) {
 	public, private := keypair(t)
 	var (
-		password = NewPasswordArchive([]byte("secret"), 1, 8, &Buffer{})
-		curve448 = NewCurve448Archive(public, private, &Buffer{})
+		password = NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+		curve448 = NewCurve448Archive([]*PublicKey{public}, private, &Buffer{})
 		shard    = NewShardArchive(2, buffers(2))
 	)
 
@@ -219,12 +517,18 @@ func TestWrongArchiveType(t *testing.T) {
 	createArchive(t, curve448)
 	createArchive(t, shard)
 
-	ensureInvalidType(t, NewPasswordArchive([]byte("secret"), 1, 8, curve448.File))
-	ensureInvalidType(t, NewPasswordArchive([]byte("secret"), 1, 8, shard.Shards[0].File))
-	ensureInvalidType(t, NewCurve448Archive(public, private, password.File))
-	ensureInvalidType(t, NewCurve448Archive(public, private, shard.Shards[0].File))
+	ensureInvalidType(t, NewPasswordArchive(secret("secret"), 1, 8, 1, curve448.File))
+	ensureInvalidType(t, NewPasswordArchive(secret("secret"), 1, 8, 1, shard.Shards[0].File))
+	ensureInvalidType(t, NewCurve448Archive([]*PublicKey{public}, private, password.File))
+	ensureInvalidType(t, NewCurve448Archive([]*PublicKey{public}, private, shard.Shards[0].File))
 	ensureInvalidType(t, NewShardArchive(2, []File{password.File}))
 	ensureInvalidType(t, NewShardArchive(2, []File{curve448.File}))
+
+	slots := NewKeySlotArchive(slotKeys(secret("secret"), nil), nil, nil, &Buffer{})
+	createArchive(t, slots)
+
+	ensureInvalidType(t, NewPasswordArchive(secret("secret"), 1, 8, 1, slots.File))
+	ensureInvalidType(t, NewKeySlotArchive(nil, secret("secret"), nil, password.File))
 }
 
 func createArchive(t *testing.T, a Archiver) [][]byte {
@@ -253,23 +557,16 @@ func createArchive(t *testing.T, a Archiver) [][]byte {
 		}
 	}
 
-	writer.Close()
-
-	switch a := a.(type) {
-	case *PasswordArchive:
-		a.File.(*Buffer).Rewind()
-	case *Curve448Archive:
-		a.File.(*Buffer).Rewind()
-	case *ShardArchive:
-		for _, s := range a.Shards {
-			s.File.(*Buffer).Rewind()
-		}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
 	}
 
+	rewind(a)
+
 	return dat
 }
 
-func verifyArchive(t *testing.T, a Archiver, dat [][]byte) {
+func verifyArchive(t *testing.T, a Archiver, dat [][]byte) *Reader {
 	reader, err := a.Reader()
 	if err != nil {
 		t.Fatal(err)
@@ -298,6 +595,21 @@ func verifyArchive(t *testing.T, a Archiver, dat [][]byte) {
 	if !reader.Verify() {
 		t.Fatalf("archive verify failed")
 	}
+
+	return reader
+}
+
+func ensureDamaged(t *testing.T, r *Reader, files ...File) {
+	damaged := r.Damaged()
+	if len(damaged) != len(files) {
+		t.Fatalf("expected %d damaged shards got %d", len(files), len(damaged))
+	}
+
+	for i, file := range files {
+		if damaged[i] != file {
+			t.Fatalf("expected shard %d to be damaged", i)
+		}
+	}
 }
 
 func ensureInvalid(t *testing.T, a Archiver) {
@@ -309,29 +621,61 @@ func ensureInvalid(t *testing.T, a Archiver) {
 	}
 }
 
+func ensureMissing(t *testing.T, a *ShardArchive, need int) {
+	_, err := a.Reader()
+	if err, ok := err.(*MissingShardsError); !ok || err.Need != need {
+		t.Fatalf("expected %d missing shards got %v", need, err)
+	}
+}
+
 func ensureInvalidType(t *testing.T, a Archiver) {
 	switch _, err := a.Reader(); {
 	case err == ErrPasswordArchive:
 	case err == ErrCurve448Archive:
 	case err == ErrShardArchive:
+	case err == ErrKeySlotArchive:
 	case err != nil:
 		t.Fatal("error checking archive type", err)
 	case err == nil:
 		t.Fatal("invalid archive type accepted")
 	}
 
+	rewind(a)
+}
+
+func rewind(a Archiver) {
+	var files []File
+
 	switch a := a.(type) {
 	case *PasswordArchive:
-		a.File.(*Buffer).Rewind()
+		files = []File{a.File}
 	case *Curve448Archive:
-		a.File.(*Buffer).Rewind()
+		files = []File{a.File}
 	case *ShardArchive:
-		for _, s := range a.Shards {
-			s.File.(*Buffer).Rewind()
+		files = a.Files()
+	case *KeySlotArchive:
+		files = []File{a.File}
+	}
+
+	for _, f := range files {
+		if b, ok := f.(*Buffer); ok {
+			b.Rewind()
 		}
 	}
 }
 
+func digest(t *testing.T, r io.Reader) []byte {
+	b := make([]byte, SumSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func secret(s string) *Secret {
+	return CopySecret([]byte(s))
+}
+
 func keypair(t *testing.T) (*PublicKey, *PrivateKey) {
 	public, private, err := GenerateKeypair()
 	if err != nil {
@@ -396,3 +740,9 @@ func (b *Buffer) Close() error {
 func (b *Buffer) Rewind() {
 	b.offset = 0
 }
+
+type Pipe struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
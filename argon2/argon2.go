@@ -0,0 +1,75 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+// Package argon2 derives keys with version 1.3 of the Argon2 password
+// hashing function. Argon2i and Argon2id are computed by the vendored
+// golang.org/x/crypto/argon2, Argon2d, which only version 1 archives and
+// keys use, by the vendored github.com/magical/argon2 in a single lane.
+package argon2
+
+import (
+	"errors"
+
+	legacy "github.com/magical/argon2"
+	"golang.org/x/crypto/argon2"
+)
+
+// A Variant selects how Argon2 chooses the blocks it references.
+// Argon2d uses data-dependent addressing, Argon2i data-independent
+// addressing, and Argon2id data-independent addressing for the first
+// half of its first pass and data-dependent addressing thereafter.
+type Variant byte
+
+const (
+	Argon2d  Variant = 0
+	Argon2i  Variant = 1
+	Argon2id Variant = 2
+)
+
+const (
+	minSalt = 8
+	minSize = 4
+)
+
+var (
+	ErrInvalidVariant = errors.New("argon2: invalid variant")
+	ErrInvalidCost    = errors.New("argon2: invalid iterations, memory, or lanes")
+	ErrInvalidSalt    = errors.New("argon2: salt too short")
+	ErrInvalidSize    = errors.New("argon2: key too short")
+)
+
+func (v Variant) String() string {
+	switch v {
+	case Argon2d:
+		return "argon2d"
+	case Argon2i:
+		return "argon2i"
+	case Argon2id:
+		return "argon2id"
+	}
+	return "unknown"
+}
+
+// Key derives a key of size bytes from the password and salt using
+// the given number of iterations, memory in KiB, and lanes. Memory must
+// be at least 8 KiB per lane and is rounded down to a multiple of 4 KiB
+// per lane. Argon2d is only computed in a single lane.
+func Key(password, salt []byte, variant Variant, iterations, memory uint32, lanes uint8, size int) ([]byte, error) {
+	switch {
+	case variant > Argon2id || variant == Argon2d && lanes > 1:
+		return nil, ErrInvalidVariant
+	case iterations < 1 || lanes < 1 || memory < 8*uint32(lanes):
+		return nil, ErrInvalidCost
+	case len(salt) < minSalt:
+		return nil, ErrInvalidSalt
+	case size < minSize:
+		return nil, ErrInvalidSize
+	}
+
+	switch variant {
+	case Argon2d:
+		return legacy.Key(password, salt, int(iterations), 1, int64(memory), size)
+	case Argon2i:
+		return argon2.Key(password, salt, iterations, memory, lanes, uint32(size)), nil
+	}
+	return argon2.IDKey(password, salt, iterations, memory, lanes, uint32(size)), nil
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package argon2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Keys derived by the reference implementation of RFC 9106 without the
+// optional secret or associated data.
+func TestVectors(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+
+	vectors := []struct {
+		variant Variant
+		lanes   uint8
+		key     string
+	}{
+		{Argon2d, 1, "e0e10a2d2bea133e8df7c62f2653f7188e4287b8ab730753ee3383f27000db7e"},
+		{Argon2i, 4, "a9a7510e6db4d588ba3414cd0e094d480d683f97b9ccb612a544fe8ef65ba8e0"},
+		{Argon2id, 4, "03aab965c12001c9d7d0d2de33192c0494b684bb148196d73c1df1acaf6d0c2e"},
+	}
+
+	for _, v := range vectors {
+		key, err := Key(password, salt, v.variant, 3, 32, v.lanes, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(key) != v.key {
+			t.Fatalf("%s: expected %s got %x", v.variant, v.key, key)
+		}
+	}
+}
+
+func TestKeyLength(t *testing.T) {
+	salt := make([]byte, 16)
+	for _, size := range []int{4, 32, 64, 65, 100, 128, 1024} {
+		key, err := Key([]byte("secret"), salt, Argon2id, 1, 64, 2, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(key) != size {
+			t.Fatalf("expected %d byte key got %d", size, len(key))
+		}
+	}
+}
+
+func TestLanes(t *testing.T) {
+	salt := make([]byte, 16)
+	key1 := key(t, salt, 1)
+	key4 := key(t, salt, 4)
+
+	if bytes.Equal(key1, key4) {
+		t.Fatal("keys derived with different lanes match")
+	}
+
+	if !bytes.Equal(key4, key(t, salt, 4)) {
+		t.Fatal("keys derived with the same lanes differ")
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	salt := make([]byte, 16)
+
+	tests := []struct {
+		variant    Variant
+		iterations uint32
+		memory     uint32
+		lanes      uint8
+		salt       []byte
+		size       int
+		err        error
+	}{
+		{Argon2id + 1, 1, 64, 1, salt, 32, ErrInvalidVariant},
+		{Argon2d, 1, 64, 2, salt, 32, ErrInvalidVariant},
+		{Argon2id, 0, 64, 1, salt, 32, ErrInvalidCost},
+		{Argon2id, 1, 64, 0, salt, 32, ErrInvalidCost},
+		{Argon2id, 1, 31, 4, salt, 32, ErrInvalidCost},
+		{Argon2id, 1, 64, 1, salt[:7], 32, ErrInvalidSalt},
+		{Argon2id, 1, 64, 1, salt, 3, ErrInvalidSize},
+	}
+
+	for _, test := range tests {
+		_, err := Key([]byte("secret"), test.salt, test.variant, test.iterations, test.memory, test.lanes, test.size)
+		if err != test.err {
+			t.Fatalf("expected %v got %v", test.err, err)
+		}
+	}
+}
+
+func key(t *testing.T, salt []byte, lanes uint8) []byte {
+	key, err := Key([]byte("secret"), salt, Argon2id, 2, 256, lanes, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
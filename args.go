@@ -7,52 +7,94 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
+	"unsafe"
 
 	"github.com/jessevdk/go-flags"
-
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 type Args struct {
 	OperationMode        `group:"Archive Operation Mode"`
 	OperationModifier    `group:"Archive Operation Modifiers"`
 	KeyManagementMode    `group:"Key Management Mode"`
+	KeySlotMode          `group:"Key Slot Mode"`
 	SecurityOptions      `group:"Archive Security Options"`
 	PasswordOptions      `group:"Password Options"`
 	KeyManagementOptions `group:"Key Generation Options"`
 	MiscOpts             `group:"Misc Options"`
 	Positional           `positional-args:"true" required:"0"`
+
+	passwords []*Secret
+	keys      []*Secret
 }
 
 type OperationMode struct {
 	Create  bool `short:"c" long:"create"  description:"create new archive"`
 	List    bool `short:"t" long:"list"    description:"list archive contents"`
 	Extract bool `short:"x" long:"extract" description:"extract from archive"`
+	Info    bool `          long:"info"    description:"show archive header"`
 }
 
 type OperationModifier struct {
-	File   string   `short:"f" long:"file"  description:"archive file"`
-	Shards []string `          long:"shard" description:"archive shard"`
+	File      string   `short:"f" long:"file"             description:"archive file, - for stdin or stdout"`
+	Shards    []string `          long:"shard"            description:"archive shard"`
+	JSON      bool     `          long:"json"             description:"show --info as JSON"`
+	Absolute  bool     `short:"P" long:"absolute-names"   description:"don't remove leading / or reject .. in extracted names"`
+	Directory string   `short:"C" long:"directory"        description:"create from or extract to directory"`
+	Strip     int      `          long:"strip-components" description:"remove leading path components when extracting"`
+	Transform []string `          long:"transform"        description:"rewrite names with sed-style s/regexp/replacement/flags"`
+	Numeric   bool     `          long:"numeric-owner"    description:"store and restore owners by ID rather than name"`
+	NoOwner   bool     `          long:"no-same-owner"    description:"extract files owned by the current user"`
+	AllXattrs bool     `          long:"all-xattrs"       description:"restore xattrs of every namespace, not only user"`
+	Overwrite bool     `          long:"overwrite"        description:"replace existing files when extracting"`
+	KeepNewer bool     `          long:"keep-newer-files" description:"replace existing files older than the archived file"`
+	SkipOld   bool     `          long:"skip-old-files"   description:"don't replace existing files (default)"`
+	Backup    string   `          long:"backup"           description:"back up replaced files, simple or numbered" optional:"yes" optional-value:"simple"`
+	Stage     bool     `          long:"transactional"    description:"extract to a staging directory and move into place once verified"`
+	ToStdout  bool     `short:"O" long:"to-stdout"        description:"extract files to stdout"`
+	ToCommand string   `          long:"to-command"       description:"pipe each extracted entry to a shell command"`
 }
 
 type SecurityOptions struct {
-	Password  bool   `long:"password"  description:"derive key from password"`
-	Key       string `long:"key"       description:"derive key from ECDH exchange"`
-	Threshold int    `long:"threshold" description:"random key with SSS threshold"`
+	Password  bool     `long:"password"      description:"derive key from password"`
+	Keys      []string `long:"key"           description:"derive key from ECDH exchange with each key"`
+	Threshold int      `long:"threshold"     description:"random key with SSS threshold"`
+	Disperse  bool     `long:"disperse"      description:"split data across shards instead of copying it"`
+	KeySlots  bool     `long:"keyslots"      description:"random key in password and key slots"`
+	Unlock    string   `long:"unlock-key"    description:"private key to open key slots"`
+	Sign      string   `long:"sign"          description:"sign archive with private signing key"`
+	Signer    string   `long:"verify-signer" description:"require signature by public signing key"`
 }
 
 type KeyManagementMode struct {
-	Keygen bool `long:"keygen" description:"generate key pair"`
+	Keygen    bool `long:"keygen"    description:"generate key pair"`
+	Calibrate bool `long:"calibrate" description:"show the strongest argon2 cost taking --kdf-target"`
+}
+
+type KeySlotMode struct {
+	AddSlot    bool `long:"add-slot"    description:"add --password or --key slot"`
+	RemoveSlot int  `long:"remove-slot" description:"remove numbered key slot"`
+	ListSlots  bool `long:"list-slots"  description:"list key slots"`
 }
 
 type KeyManagementOptions struct {
 	Private string `long:"private" description:"private key file"`
 	Public  string `long:"public"  description:"public key file"`
+	Signing bool   `long:"signing" description:"generate signing key pair"`
 }
 
 type PasswordOptions struct {
-	Iterations uint32 `long:"iterations" description:"argon2 iterations"`
-	Memory     uint32 `long:"memory"     description:"argon2 memory use"`
+	Iterations    uint32        `long:"iterations"     description:"argon2 iterations"`
+	Memory        KiB           `long:"memory"         description:"argon2 memory use, in KiB or with a K, M, or G suffix"`
+	Lanes         uint8         `long:"lanes"          description:"argon2 lanes computed in parallel"`
+	Target        time.Duration `long:"kdf-target"     description:"choose argon2 iterations and memory, up to --memory, taking this long"`
+	MaxIterations uint32        `long:"max-iterations" description:"highest argon2 iterations to accept when opening"`
+	MaxMemory     KiB           `long:"max-memory"     description:"highest argon2 memory use to accept when opening"`
+
+	PasswordFile    string `long:"password-file"    description:"read the password from the first line of a file"`
+	PasswordFD      int    `long:"password-fd"      description:"read the password from the first line of a file descriptor"`
+	PasswordEnv     string `long:"password-env"     description:"read the password from an environment variable"`
+	PasswordCommand string `long:"password-command" description:"read the password from the first line of a shell command's output"`
 }
 
 type MiscOpts struct {
@@ -71,8 +113,72 @@ func NewCommand() (*Cmd, error) {
 	}
 
 	c := &Cmd{
-		Verbose: len(args.Verbose),
-		Names:   args.Names,
+		Verbose:       len(args.Verbose),
+		Names:         args.Names,
+		Root:          ".",
+		Strip:         args.Strip,
+		Absolute:      args.Absolute,
+		NumericOwner:  args.Numeric,
+		SameOwner:     os.Geteuid() == 0 && !args.NoOwner,
+		AllXattrs:     args.AllXattrs,
+		Backup:        args.Backup,
+		Transactional: args.Stage || args.Signer != "",
+		Log:           os.Stdout,
+		secrets:       args,
+	}
+
+	Limits = CostLimits{
+		Iterations: args.MaxIterations,
+		Memory:     uint32(args.MaxMemory),
+	}
+
+	if args.Create && (args.File == "-" || count(args.Shards, "-") > 0) {
+		c.Log = os.Stderr
+	}
+
+	if args.Calibrate || args.Target > 0 {
+		target := args.Target
+		if target == 0 {
+			target = DefaultTarget
+		}
+
+		c.Cost, err = Calibrate(target, uint32(args.Memory), args.Lanes)
+		if err != nil {
+			return nil, err
+		}
+
+		args.Iterations = c.Cost.Iterations
+		args.Memory = KiB(c.Cost.Memory)
+
+		if !args.Calibrate {
+			fmt.Fprintln(os.Stderr, c.Cost)
+		}
+	}
+
+	for _, expr := range args.Transform {
+		t, err := ParseTransform(expr)
+		if err != nil {
+			return nil, fmt.Errorf("--transform %s: %s", expr, err)
+		}
+		c.Transforms = append(c.Transforms, t)
+	}
+
+	switch {
+	case args.KeepNewer:
+		c.Policy = KeepNewer
+	case args.Overwrite || args.Backup != "":
+		c.Policy = Overwrite
+	}
+
+	if args.Directory != "" {
+		info, err := os.Stat(args.Directory)
+		switch {
+		case err != nil:
+			return nil, err
+		case !info.IsDir():
+			return nil, fmt.Errorf("%s is not a directory", args.Directory)
+		}
+		c.Root = args.Directory
 	}
 
 	var mode int
@@ -83,17 +189,51 @@ func NewCommand() (*Cmd, error) {
 	case args.List:
 		c.Op = c.List
 		mode = os.O_RDONLY
+	case args.Extract && args.ToStdout:
+		c.Op = c.ToStdout
+		mode = os.O_RDONLY
+	case args.Extract && args.ToCommand != "":
+		c.Op = c.ToCommand
+		c.Command = args.ToCommand
+		mode = os.O_RDONLY
 	case args.Extract:
 		c.Op = c.Extract
 		mode = os.O_RDONLY
+	case args.Info:
+		c.Op = c.Info
+		c.Paths = args.Shards
+		c.JSON = args.JSON
+		if args.File != "" {
+			c.Paths = []string{args.File}
+		}
+	case args.Keygen && args.Signing:
+		c.Op = c.SigningKeygen
 	case args.Keygen:
 		c.Op = c.Keygen
+	case args.Calibrate:
+		c.Op = c.Calibrate
+	case args.AddSlot:
+		c.Op = c.AddSlot
+		mode = os.O_RDWR
+	case args.RemoveSlot > 0:
+		c.Op = c.RemoveSlot
+		c.Slot = args.RemoveSlot
+		mode = os.O_RDWR
+	case args.ListSlots:
+		c.Op = c.ListSlots
+		mode = os.O_RDONLY
 	}
 
 	switch {
+	case args.Info:
+		// headers are read without an Archiver
+	case args.AddSlot:
+		c.Archiver, c.SlotKey, err = args.PrepareAddSlot(mode)
+	case args.KeySlots || args.SlotOperation():
+		c.Archiver, err = args.PrepareKeySlotArchive(mode)
 	case args.Password:
 		c.Archiver, err = args.PreparePasswordArchive(mode)
-	case args.Key != "":
+	case len(args.Keys) > 0:
 		c.Archiver, err = args.PrepareCurve448Archive(mode)
 	case len(args.Shards) > 0:
 		c.Archiver, err = args.PrepareShardArchive(mode)
@@ -101,14 +241,26 @@ func NewCommand() (*Cmd, error) {
 		c.Public, c.Private, err = args.PrepareKeygen()
 	}
 
+	if err == nil && (args.Sign != "" || args.Signer != "") {
+		err = args.PrepareSigning(c.Archiver)
+	}
+
+	if err != nil {
+		args.Close()
+	}
+
 	return c, err
 }
 
 func ParseArgs(arg ...string) (*Args, error) {
 	args := &Args{
 		PasswordOptions: PasswordOptions{
-			Iterations: 3,
-			Memory:     16,
+			Iterations:    3,
+			Memory:        256 << 10,
+			Lanes:         4,
+			MaxIterations: Limits.Iterations,
+			MaxMemory:     KiB(Limits.Memory),
+			PasswordFD:    -1,
 		},
 	}
 
@@ -131,32 +283,121 @@ func ParseArgs(arg ...string) (*Args, error) {
 
 func (a *Args) Validate() error {
 	switch {
-	case !a.Create && !a.List && !a.Extract && !a.Keygen:
-		return fmt.Errorf("must specify one of -c, -t, -x, --keygen")
+	case a.Operations() == 0:
+		return fmt.Errorf("must specify one of -c, -t, -x, --info, --keygen, --calibrate, --add-slot, --remove-slot, --list-slots")
 
-	case a.Create && (a.List || a.Extract || a.Keygen):
+	case a.Create && a.Operations() > 1:
 		return fmt.Errorf("can't combine -c, --create with other operations")
-	case a.List && (a.Create || a.Extract || a.Keygen):
+	case a.List && a.Operations() > 1:
 		return fmt.Errorf("can't combine -t, --list with other operations")
-	case a.Extract && (a.Create || a.List || a.Keygen):
+	case a.Extract && a.Operations() > 1:
 		return fmt.Errorf("can't combine -x, --extract with other operations")
-	case a.Keygen && (a.Create || a.Extract || a.List):
+	case a.Info && a.Operations() > 1:
+		return fmt.Errorf("can't combine --info with other operations")
+	case a.Keygen && a.Operations() > 1:
 		return fmt.Errorf("can't combine --keygen with other operations")
-
-	case a.Create && !a.Password && a.Key == "" && len(a.Shards) == 0:
+	case a.Calibrate && a.Operations() > 1:
+		return fmt.Errorf("can't combine --calibrate with other operations")
+	case a.SlotOperation() && a.Operations() > 1:
+		return fmt.Errorf("can't combine --add-slot, --remove-slot, --list-slots with other operations")
+
+	case a.SlotOperation() && (a.File == "" || a.File == "-"):
+		return fmt.Errorf("key slot operations require -f, --file")
+	case a.SlotOperation() && len(a.Shards) > 0:
+		return fmt.Errorf("can't combine key slot operations with --shard")
+	case a.AddSlot && a.Password == (len(a.Keys) > 0):
+		return fmt.Errorf("add slot requires one of --password or --key")
+	case a.RemoveSlot > 0 && !a.Password && len(a.Keys) == 0:
+		return fmt.Errorf("remove slot requires --password or --key")
+	case a.RemoveSlot < 0 || a.RemoveSlot > MaxSlots:
+		return fmt.Errorf("--remove-slot must be between 1 and %d", MaxSlots)
+	case a.Unlock != "" && !a.AddSlot:
+		return fmt.Errorf("--unlock-key requires --add-slot")
+	case a.KeySlots && len(a.Shards) > 0:
+		return fmt.Errorf("can't combine --keyslots with --shard")
+	case a.KeySlots && a.Create && len(a.Keys)+btoi(a.Password) > MaxSlots:
+		return fmt.Errorf("can't use more than %d key slots", MaxSlots)
+
+	case a.Info && (a.Password || len(a.Keys) > 0 || a.KeySlots):
+		return fmt.Errorf("--info does not take --password, --key, or --keyslots")
+	case a.Calibrate && (a.Password || len(a.Keys) > 0 || a.KeySlots || a.File != "" || len(a.Shards) > 0):
+		return fmt.Errorf("--calibrate does not take --password, --key, --keyslots, -f, or --shard")
+	case a.JSON && !a.Info:
+		return fmt.Errorf("--json requires --info")
+	case a.Absolute && !a.Create && !a.Extract:
+		return fmt.Errorf("-P, --absolute-names requires -c, --create or -x, --extract")
+	case len(a.Transform) > 0 && !a.Create && !a.Extract:
+		return fmt.Errorf("--transform requires -c, --create or -x, --extract")
+	case a.Directory != "" && !a.Create && !a.Extract:
+		return fmt.Errorf("-C, --directory requires -c, --create or -x, --extract")
+	case a.Strip != 0 && !a.Extract:
+		return fmt.Errorf("--strip-components requires -x, --extract")
+	case a.Strip < 0:
+		return fmt.Errorf("--strip-components must be >= 0")
+	case a.Numeric && !a.Create && !a.Extract:
+		return fmt.Errorf("--numeric-owner requires -c, --create or -x, --extract")
+	case a.NoOwner && !a.Extract:
+		return fmt.Errorf("--no-same-owner requires -x, --extract")
+	case (a.Overwrite || a.KeepNewer || a.SkipOld || a.Backup != "") && !a.Extract:
+		return fmt.Errorf("--overwrite, --keep-newer-files, --skip-old-files, --backup require -x, --extract")
+	case btoi(a.Overwrite)+btoi(a.KeepNewer)+btoi(a.SkipOld) > 1:
+		return fmt.Errorf("can't combine --overwrite, --keep-newer-files, --skip-old-files")
+	case a.SkipOld && a.Backup != "":
+		return fmt.Errorf("can't combine --skip-old-files with --backup")
+	case a.Backup != "" && a.Backup != SimpleBackup && a.Backup != NumberedBackup:
+		return fmt.Errorf("--backup must be simple or numbered")
+	case a.Stage && !a.Extract:
+		return fmt.Errorf("--transactional requires -x, --extract")
+	case a.Stage && a.Absolute:
+		return fmt.Errorf("can't combine --transactional with -P, --absolute-names")
+	case a.AllXattrs && !a.Extract:
+		return fmt.Errorf("--all-xattrs requires -x, --extract")
+	case (a.ToStdout || a.ToCommand != "") && !a.Extract:
+		return fmt.Errorf("-O, --to-stdout and --to-command require -x, --extract")
+	case a.ToStdout && a.ToCommand != "":
+		return fmt.Errorf("can't combine -O, --to-stdout with --to-command")
+	case (a.ToStdout || a.ToCommand != "") && (a.Stage || a.Directory != "" || a.Absolute):
+		return fmt.Errorf("-O, --to-stdout and --to-command don't write files")
+
+	case (a.Create || a.AddSlot || a.Keygen) && (a.Iterations > a.MaxIterations || a.Memory > a.MaxMemory):
+		return fmt.Errorf("--iterations and --memory must not exceed --max-iterations and --max-memory")
+	case a.Target < 0:
+		return fmt.Errorf("--kdf-target must not be negative")
+	case a.Target > 0 && !a.Calibrate && !a.Keygen && !((a.Create || a.AddSlot) && a.Password):
+		return fmt.Errorf("--kdf-target requires --calibrate, --keygen, or --password with -c, --create or --add-slot")
+	case a.Iterations < 1 || a.Lanes < 1:
+		return fmt.Errorf("--iterations and --lanes must be at least 1")
+	case a.Memory < 8*KiB(a.Lanes):
+		return fmt.Errorf("--memory must be at least 8K per lane")
+
+	case a.PasswordSources() > 1:
+		return fmt.Errorf("can't combine --password-file, --password-fd, --password-env, --password-command")
+	case a.PasswordFD < -1:
+		return fmt.Errorf("--password-fd must not be negative")
+	case a.PasswordSources() > 0 && !a.Password && len(a.Keys) == 0 && a.Unlock == "" && a.Sign == "" && !a.Keygen:
+		return fmt.Errorf("--password-file, --password-fd, --password-env, --password-command require --password, --key, --unlock-key, --sign, or --keygen")
+	case a.PasswordFD == 0 && (a.File == "-" || count(a.Shards, "-") > 0):
+		return fmt.Errorf("can't use --password-fd 0 with - for -f, --file or --shard")
+
+	case a.Create && !a.Password && len(a.Keys) == 0 && len(a.Shards) == 0:
 		return fmt.Errorf("create requires --password, --key, or --shard")
-	case a.List && !a.Password && a.Key == "" && len(a.Shards) == 0:
+	case a.List && !a.Password && len(a.Keys) == 0 && len(a.Shards) == 0:
 		return fmt.Errorf("list requires --password, --key, or --shard")
-	case a.Extract && !a.Password && a.Key == "" && len(a.Shards) == 0:
+	case a.Extract && !a.Password && len(a.Keys) == 0 && len(a.Shards) == 0:
 		return fmt.Errorf("extract requires --password, --key, or --shard")
 
-	case a.Password && a.Key != "":
+	case a.Password && len(a.Keys) > 0 && !(a.KeySlots && a.Create):
 		return fmt.Errorf("can't combine --password with --key")
 	case a.Password && len(a.Shards) > 0:
 		return fmt.Errorf("can't combine --password with --shard")
-	case a.Key != "" && len(a.Shards) > 0:
+	case len(a.Keys) > 0 && len(a.Shards) > 0:
 		return fmt.Errorf("can't combine --key with --shard")
 
+	case len(a.Keys) > 255:
+		return fmt.Errorf("can't use more than 255 keys")
+	case !a.Create && len(a.Keys) > 1:
+		return fmt.Errorf("can't use more than one --key without -c, --create")
+
 	case len(a.Shards) > 255:
 		return fmt.Errorf("can't use more than 255 shards")
 	case a.Create && len(a.Shards) > 0 && len(a.Shards) < 2:
@@ -165,16 +406,31 @@ func (a *Args) Validate() error {
 		return fmt.Errorf("--threshold must be > 1")
 	case a.Create && len(a.Shards) > 0 && a.Threshold > len(a.Shards):
 		return fmt.Errorf("--threshold must be <= %d", len(a.Shards))
+	case a.Disperse && (!a.Create || len(a.Shards) == 0):
+		return fmt.Errorf("--disperse requires -c, --create and --shard")
 
-	case !a.Keygen && (a.Password || a.Key != "") && a.File == "":
+	case !a.Keygen && (a.Password || len(a.Keys) > 0) && a.File == "":
 		return fmt.Errorf("must provide -f, --file")
-	case !a.Keygen && !a.Password && a.Key == "" && a.File == "" && len(a.Shards) == 0:
+	case !a.Keygen && !a.Calibrate && !a.SlotOperation() && !a.Password && len(a.Keys) == 0 && a.File == "" && len(a.Shards) == 0:
 		return fmt.Errorf("must provide -f, --file or --shard")
 	case !a.Keygen && a.File != "" && len(a.Shards) > 0:
 		return fmt.Errorf("can't combine -f, --file and --shard")
+	case count(a.Shards, "-") > 1:
+		return fmt.Errorf("can't use - for more than one --shard")
 
 	case a.Keygen && (a.Public == "" || a.Private == ""):
 		return fmt.Errorf("keygen requires --public and --private")
+	case a.Signing && !a.Keygen:
+		return fmt.Errorf("--signing requires --keygen")
+
+	case a.Sign != "" && !a.Create:
+		return fmt.Errorf("--sign requires -c, --create")
+	case a.Signer != "" && !a.List && !a.Extract:
+		return fmt.Errorf("--verify-signer requires -t, --list or -x, --extract")
+	case a.Signer != "" && a.Absolute:
+		return fmt.Errorf("can't combine --verify-signer with -P, --absolute-names")
+	case a.Signer != "" && (a.ToStdout || a.ToCommand != ""):
+		return fmt.Errorf("can't combine --verify-signer with -O, --to-stdout or --to-command")
 
 	case a.Create && len(a.Names) == 0:
 		return fmt.Errorf("no files or directories specified")
@@ -183,73 +439,224 @@ func (a *Args) Validate() error {
 	return nil
 }
 
+// Operations returns the number of operations specified.
+func (a *Args) Operations() int {
+	ops := []bool{a.Create, a.List, a.Extract, a.Info, a.Keygen, a.Calibrate, a.AddSlot, a.RemoveSlot != 0, a.ListSlots}
+	n := 0
+	for _, op := range ops {
+		n += btoi(op)
+	}
+	return n
+}
+
+// SlotOperation reports whether a key slot operation was specified.
+func (a *Args) SlotOperation() bool {
+	return a.AddSlot || a.RemoveSlot != 0 || a.ListSlots
+}
+
 func (a *Args) PreparePasswordArchive(mode int) (Archiver, error) {
-	file, err := os.OpenFile(a.File, mode, 0600)
+	password, err := a.ReadPassword("password: ", mode&os.O_CREATE == os.O_CREATE)
 	if err != nil {
 		return nil, err
 	}
 
-	password, err := ReadPassword()
+	file, err := OpenFile(a.File, mode)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewPasswordArchive(password, a.Iterations, a.Memory, file), nil
+	return NewPasswordArchive(password, a.Iterations, uint32(a.Memory), a.Lanes, file), nil
 }
 
 func (a *Args) PrepareCurve448Archive(mode int) (Archiver, error) {
-	var publicKey PublicKey
-	var privateKey PrivateKey
-	var err error
+	var publicKeys []*PublicKey
+	var privateKey *PrivateKey
 
 	if mode&os.O_CREATE == os.O_CREATE {
-		err = a.LoadPublicKey(&publicKey)
+		publicKeys = make([]*PublicKey, len(a.Keys))
+		for i, path := range a.Keys {
+			publicKeys[i] = &PublicKey{}
+			err := a.LoadPublicKey(path, publicKeys[i])
+			if err != nil {
+				return nil, fmt.Errorf("file %s: %s", path, err)
+			}
+		}
 	} else {
-		err = a.LoadPrivateKey(&privateKey)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("file %s: %s", a.Key, err)
+		privateKey = a.NewPrivateKey()
+		err := a.LoadPrivateKey(a.Keys[0], privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %s", a.Keys[0], err)
+		}
 	}
 
-	file, err := os.OpenFile(a.File, mode, 0600)
+	file, err := OpenFile(a.File, mode)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewCurve448Archive(&publicKey, &privateKey, file), nil
+	return NewCurve448Archive(publicKeys, privateKey, file), nil
 }
 
 func (a *Args) PrepareShardArchive(mode int) (Archiver, error) {
 	files := make([]File, len(a.Shards))
 	for i, path := range a.Shards {
-		file, err := os.OpenFile(path, mode, 0600)
+		file, err := OpenFile(path, mode)
 		if err != nil {
 			return nil, err
 		}
 		files[i] = file
 	}
+
+	if a.Disperse {
+		return NewDispersedShardArchive(a.Threshold, files), nil
+	}
+
 	return NewShardArchive(a.Threshold, files), nil
 }
 
-func (a *Args) PrepareKeygen() (public *KeyContainer, private *KeyContainer, err error) {
-	mode := os.O_EXCL | os.O_CREATE | os.O_WRONLY
+func (a *Args) PrepareKeySlotArchive(mode int) (Archiver, error) {
+	var keys []SlotKey
+	var password *Secret
+	var privateKey *PrivateKey
+	var err error
+
+	switch {
+	case mode&os.O_CREATE == os.O_CREATE:
+		keys, err = a.SlotKeys()
+	case a.Password:
+		password, err = a.ReadPassword("password: ", false)
+	case len(a.Keys) > 0:
+		privateKey = a.NewPrivateKey()
+		err = a.LoadPrivateKey(a.Keys[0], privateKey)
+		if err != nil {
+			err = fmt.Errorf("file %s: %s", a.Keys[0], err)
+		}
+	}
 
-	public, err = a.OpenPublicKeyContainer(a.Public, mode)
 	if err != nil {
-		return nil, nil, fmt.Errorf("can't create public key: %s", err)
+		return nil, err
+	}
+
+	file, err := OpenFile(a.File, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeySlotArchive(keys, password, privateKey, file), nil
+}
+
+func (a *Args) PrepareAddSlot(mode int) (Archiver, *SlotKey, error) {
+	var password *Secret
+	var privateKey *PrivateKey
+	var err error
+
+	switch {
+	case a.Unlock != "":
+		privateKey = a.NewPrivateKey()
+		err = a.LoadPrivateKey(a.Unlock, privateKey)
+		if err != nil {
+			err = fmt.Errorf("file %s: %s", a.Unlock, err)
+		}
+	default:
+		password, err = a.ReadPassword("password: ", false)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := a.SlotKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := OpenFile(a.File, mode)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	return NewKeySlotArchive(nil, password, privateKey, file), &keys[0], nil
+}
+
+// SlotKeys returns a SlotKey for the --password and each --key.
+func (a *Args) SlotKeys() ([]SlotKey, error) {
+	var keys []SlotKey
+
+	if a.Password {
+		prompt := "password: "
+		if a.AddSlot {
+			prompt = "new password: "
+		}
+
+		password, err := a.ReadPassword(prompt, true)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, SlotKey{
+			Password:   password,
+			Iterations: a.Iterations,
+			Memory:     uint32(a.Memory),
+			Lanes:      a.Lanes,
+		})
+	}
+
+	for _, path := range a.Keys {
+		publicKey := &PublicKey{}
+		err := a.LoadPublicKey(path, publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %s", path, err)
+		}
+		keys = append(keys, SlotKey{PublicKey: publicKey})
+	}
+
+	return keys, nil
+}
+
+// PrepareSigning loads the --sign private key or --verify-signer
+// public key into the archive.
+func (a *Args) PrepareSigning(arc Archiver) error {
+	var privateKey *SigningPrivateKey
+	var publicKey *SigningPublicKey
+
+	switch {
+	case a.Sign != "":
+		privateKey = a.NewSigningPrivateKey()
+		err := a.LoadSigningPrivateKey(a.Sign, privateKey)
+		if err != nil {
+			return fmt.Errorf("file %s: %s", a.Sign, err)
+		}
+	case a.Signer != "":
+		publicKey = &SigningPublicKey{}
+		err := a.LoadSigningPublicKey(a.Signer, publicKey)
+		if err != nil {
+			return fmt.Errorf("file %s: %s", a.Signer, err)
+		}
+	}
+
+	arc.SetSigning(privateKey, publicKey)
+
+	return nil
+}
+
+func (a *Args) PrepareKeygen() (public *KeyContainer, private *KeyContainer, err error) {
+	mode := os.O_EXCL | os.O_CREATE | os.O_WRONLY
+
 	private, err = a.OpenPrivateKeyContainer(a.Private, mode)
 	if err != nil {
 		return nil, nil, fmt.Errorf("can't create private key: %s", err)
 	}
 
+	public, err = a.OpenPublicKeyContainer(a.Public, mode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't create public key: %s", err)
+	}
+
 	return public, private, err
 }
 
-func (a *Args) LoadPublicKey(key *PublicKey) error {
-	c, err := a.OpenPublicKeyContainer(a.Key, os.O_RDONLY)
+func (a *Args) LoadPublicKey(path string, key *PublicKey) error {
+	c, err := a.OpenPublicKeyContainer(path, os.O_RDONLY)
 	if err != nil {
 		return err
 	}
@@ -257,8 +664,27 @@ func (a *Args) LoadPublicKey(key *PublicKey) error {
 	return c.ReadPublicKey(key)
 }
 
-func (a *Args) LoadPrivateKey(key *PrivateKey) error {
-	c, err := a.OpenPrivateKeyContainer(a.Key, os.O_RDONLY)
+// NewPrivateKey returns a PrivateKey held in a Secret until the Args are
+// closed.
+func (a *Args) NewPrivateKey() *PrivateKey {
+	return (*PrivateKey)(a.newKey(len(PrivateKey{})))
+}
+
+// NewSigningPrivateKey returns a SigningPrivateKey held in a Secret
+// until the Args are closed.
+func (a *Args) NewSigningPrivateKey() *SigningPrivateKey {
+	return (*SigningPrivateKey)(a.newKey(len(SigningPrivateKey{})))
+}
+
+// newKey returns a pointer to a key of size bytes held in a Secret.
+func (a *Args) newKey(size int) unsafe.Pointer {
+	key := NewSecret(size)
+	a.keys = append(a.keys, key)
+	return unsafe.Pointer(&key.Bytes()[0])
+}
+
+func (a *Args) LoadPrivateKey(path string, key *PrivateKey) error {
+	c, err := a.OpenPrivateKeyContainer(path, os.O_RDONLY)
 	if err != nil {
 		return err
 	}
@@ -266,31 +692,72 @@ func (a *Args) LoadPrivateKey(key *PrivateKey) error {
 	return c.ReadPrivateKey(key)
 }
 
+func (a *Args) LoadSigningPublicKey(path string, key *SigningPublicKey) error {
+	c, err := a.OpenPublicKeyContainer(path, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.ReadSigningPublicKey(key)
+}
+
+func (a *Args) LoadSigningPrivateKey(path string, key *SigningPrivateKey) error {
+	c, err := a.OpenPrivateKeyContainer(path, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.ReadSigningPrivateKey(key)
+}
+
 func (a *Args) OpenPublicKeyContainer(path string, mode int) (*KeyContainer, error) {
 	file, err := os.OpenFile(path, mode, 0600)
 	if err != nil {
 		return nil, err
 	}
-	return NewKeyContainer(file, []byte(""), 1, 8), nil
+	return NewKeyContainer(file, nil, 1, 8, 1), nil
 }
 
 func (a *Args) OpenPrivateKeyContainer(path string, mode int) (*KeyContainer, error) {
-	file, err := os.OpenFile(path, mode, 0600)
+	password, err := a.ReadPassword("password: ", mode&os.O_CREATE == os.O_CREATE)
 	if err != nil {
 		return nil, err
 	}
 
-	password, err := ReadPassword()
+	file, err := os.OpenFile(path, mode, 0600)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewKeyContainer(file, password, a.Iterations, a.Memory), nil
+	return NewKeyContainer(file, password, a.Iterations, uint32(a.Memory), a.Lanes), nil
 }
 
-func ReadPassword() ([]byte, error) {
-	fmt.Print("password: ")
-	b, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-	fmt.Print("\n")
-	return b, err
+// OpenFile opens the named archive file, or stdin or stdout when the
+// name is - depending on whether mode is for reading or writing.
+func OpenFile(path string, mode int) (File, error) {
+	switch {
+	case path != "-":
+		return os.OpenFile(path, mode, 0600)
+	case mode&(os.O_WRONLY|os.O_RDWR) != 0:
+		return os.Stdout, nil
+	default:
+		return os.Stdin, nil
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func count(values []string, value string) int {
+	n := 0
+	for _, v := range values {
+		if v == value {
+			n++
+		}
+	}
+	return n
 }
@@ -4,10 +4,23 @@
 
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
 
 type ByteSize float64
 
+// KiB is a size in KiB given as a flag value in KiB or with a suffix.
+type KiB uint32
+
+var (
+	ErrInvalidByteSize = errors.New("invalid size, expected a number with an optional B, K, M, G, or T suffix")
+)
+
 const (
 	_           = iota // ignore first value by assigning to blank identifier
 	KB ByteSize = 1 << (10 * iota)
@@ -41,3 +54,38 @@ func (b ByteSize) String() string {
 	}
 	return fmt.Sprintf("%.2fB", b)
 }
+
+// ParseByteSize parses a size formatted by String, a decimal number
+// followed by one of the suffixes B, K, M, G, T, P, E, Z, or Y in upper
+// or lower case. A number without a suffix is in the given unit.
+func ParseByteSize(s string, unit ByteSize) (ByteSize, error) {
+	suffixes := "BKMGTPEZY"
+
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte(suffixes, s[n-1]&^0x20); i >= 0 {
+			unit = ByteSize(math.Pow(1024, float64(i)))
+			s = s[:n-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, ErrInvalidByteSize
+	}
+
+	return ByteSize(f) * unit, nil
+}
+
+func (k *KiB) UnmarshalFlag(s string) error {
+	b, err := ParseByteSize(s, KB)
+	if err != nil {
+		return err
+	}
+
+	if b/KB > math.MaxUint32 {
+		return fmt.Errorf("size %s exceeds %s", s, ByteSize(math.MaxUint32)*KB)
+	}
+
+	*k = KiB(b / KB)
+	return nil
+}
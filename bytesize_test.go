@@ -0,0 +1,56 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size ByteSize
+	}{
+		{"512", 512 * KB},
+		{"0.50K", 512},
+		{"256M", 256 * MB},
+		{"256m", 256 * MB},
+		{"1.50G", 1536 * MB},
+		{"2T", 2 * TB},
+		{"100B", 100},
+	}
+
+	for _, test := range tests {
+		size, err := ParseByteSize(test.s, KB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != test.size {
+			t.Fatalf("%s: expected %v got %v", test.s, test.size, size)
+		}
+	}
+
+	for _, size := range []ByteSize{1, 1023, 64 * KB, 256 * MB, 3 * GB} {
+		if parsed, err := ParseByteSize(size.String(), KB); err != nil || parsed != size {
+			t.Fatalf("%s: expected %v got %v, %v", size, size, parsed, err)
+		}
+	}
+
+	for _, s := range []string{"", "M", "1X", "-1K", "1MB", "Inf", "NaN"} {
+		if _, err := ParseByteSize(s, KB); err != ErrInvalidByteSize {
+			t.Fatalf("%q: expected invalid size got %v", s, err)
+		}
+	}
+}
+
+func TestKiBFlag(t *testing.T) {
+	var k KiB
+
+	if err := k.UnmarshalFlag("1G"); err != nil || k != 1<<20 {
+		t.Fatalf("expected 1G as %d KiB got %d, %v", 1<<20, k, err)
+	}
+
+	if err := k.UnmarshalFlag("4T"); err == nil {
+		t.Fatal("expected 4T to exceed the largest KiB")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultTarget = 2 * time.Second
+	probeMemory   = 16 << 10
+)
+
+var (
+	ErrTargetTooShort = errors.New("archive: --kdf-target is shorter than the least argon2 cost")
+)
+
+// A Cost is a set of Argon2id parameters, with memory in KiB, and the
+// time they took to derive a key on this host.
+type Cost struct {
+	Iterations uint32
+	Memory     uint32
+	Lanes      byte
+	Time       time.Duration
+}
+
+// Calibrate returns the strongest Argon2id cost using no more than the
+// given memory and lanes that derives a key within the target time on
+// this host. Memory is what makes Argon2 costly to attack with custom
+// hardware so iterations are only raised once all of it is used.
+func Calibrate(target time.Duration, memory uint32, lanes byte) (*Cost, error) {
+	least := 8 * uint32(lanes)
+	cost := &Cost{Iterations: 1, Memory: memory, Lanes: lanes}
+
+	if memory > probeMemory {
+		probe := &Cost{Iterations: 1, Memory: probeMemory, Lanes: lanes}
+		if err := probe.measure(); err != nil {
+			return nil, err
+		}
+		cost.Memory = scale(probe.Memory, target, probe.Time, least, memory)
+	}
+
+	for {
+		if err := cost.measure(); err != nil {
+			return nil, err
+		}
+
+		if cost.Time <= target {
+			break
+		}
+
+		if cost.Memory <= least {
+			return nil, ErrTargetTooShort
+		}
+
+		cost.Memory = scale(cost.Memory, target*9/10, cost.Time, least, memory)
+	}
+
+	if cost.Memory < memory {
+		return cost, nil
+	}
+
+	// Each further iteration takes about as long as the second, which is
+	// less than the first that also fills the memory, so the iterations
+	// are estimated from the last two that fit, between the most known
+	// to fit and the least known not to.
+	best, over := cost, Limits.Iterations+1
+	for iterations := uint32(2); iterations > best.Iterations && iterations < over; {
+		more := &Cost{Iterations: iterations, Memory: memory, Lanes: lanes}
+		if err := more.measure(); err != nil {
+			return nil, err
+		}
+
+		if more.Time > target {
+			over = iterations
+			iterations = best.Iterations + (iterations-best.Iterations)/2
+			continue
+		}
+
+		pass := (more.Time - best.Time) / time.Duration(iterations-best.Iterations)
+		if pass <= 0 {
+			pass = more.Time/time.Duration(iterations) + 1
+		}
+		best = more
+
+		room := time.Duration(over - 1 - iterations)
+		if n := (target - more.Time) / pass; n < room {
+			room = n
+		}
+		iterations += uint32(room)
+	}
+
+	return best, nil
+}
+
+// Calibrate shows the cost chosen by Calibrate and the options that
+// select it.
+func (c *Cmd) Calibrate() error {
+	fmt.Println(c.Cost)
+	fmt.Println(c.Cost.Flags())
+	return nil
+}
+
+func (c *Cost) String() string {
+	memory := ByteSize(c.Memory) * KB
+	return fmt.Sprintf("argon2id, %d iterations, %s memory, %d lanes: %s",
+		c.Iterations, memory, c.Lanes, c.Time.Round(10*time.Millisecond))
+}
+
+// Flags returns the options that select the cost, with the memory in
+// the largest unit that holds it exactly.
+func (c *Cost) Flags() string {
+	memory := fmt.Sprintf("%dK", c.Memory)
+	switch {
+	case c.Memory%(1<<20) == 0:
+		memory = fmt.Sprintf("%dG", c.Memory>>20)
+	case c.Memory%(1<<10) == 0:
+		memory = fmt.Sprintf("%dM", c.Memory>>10)
+	}
+	return fmt.Sprintf("--iterations %d --memory %s --lanes %d", c.Iterations, memory, c.Lanes)
+}
+
+// measure derives a key with the cost and records the time it took.
+func (c *Cost) measure() error {
+	var salt [32]byte
+	password := CopySecret([]byte("calibrate"))
+	defer password.Close()
+
+	start := time.Now()
+	key, err := deriveKey(password, salt[:], Argon2id, c.Lanes, c.Iterations, c.Memory, KeySize)
+	c.Time = time.Since(start)
+	key.Close()
+	return err
+}
+
+// scale returns the memory expected to take the target time given that
+// memory took elapsed, between least and most and rounded down to a
+// whole MiB when larger than one.
+func scale(memory uint32, target, elapsed time.Duration, least, most uint32) uint32 {
+	scaled := float64(memory) * float64(target) / float64(elapsed)
+	switch {
+	case scaled >= float64(most):
+		return most
+	case scaled >= 1<<10:
+		scaled -= float64(uint32(scaled) % (1 << 10))
+	case scaled < float64(least):
+		return least
+	}
+	return uint32(scaled)
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestCalibrate(t *testing.T) {
+	target := 50 * time.Millisecond
+
+	cost, err := Calibrate(target, 1<<10, 2)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case cost.Time > target:
+		t.Fatalf("calibrated cost took %s, longer than %s", cost.Time, target)
+	case cost.Memory < 16 || cost.Memory > 1<<10 || cost.Lanes != 2:
+		t.Fatal("calibrated memory outside of the ceiling", cost.Memory)
+	case cost.Iterations < 1 || cost.Iterations > Limits.Iterations:
+		t.Fatal("calibrated iterations outside of the limit", cost.Iterations)
+	}
+
+	if _, err := Calibrate(time.Nanosecond, 1<<10, 2); err != ErrTargetTooShort {
+		t.Fatal("expected target too short got", err)
+	}
+}
+
+func TestScaleMemory(t *testing.T) {
+	tests := []struct {
+		memory  uint32
+		target  time.Duration
+		elapsed time.Duration
+		scaled  uint32
+	}{
+		{1 << 10, 2 * time.Second, time.Second, 2 << 10},
+		{3 << 10, time.Second, 2 * time.Second, 1 << 10},
+		{1 << 20, time.Second, time.Millisecond, 4 << 20},
+		{64, time.Millisecond, time.Second, 8},
+		{512, time.Second, 2 * time.Second, 256},
+	}
+
+	for _, test := range tests {
+		if scaled := scale(test.memory, test.target, test.elapsed, 8, 4<<20); scaled != test.scaled {
+			t.Fatalf("expected %d got %d", test.scaled, scaled)
+		}
+	}
+}
+
+func TestCostFlags(t *testing.T) {
+	for _, memory := range []uint32{8, 1000, 1 << 10, 1365 << 10, 1 << 20, 3<<20 + 1} {
+		cost := &Cost{Iterations: 3, Memory: memory, Lanes: 4}
+
+		var opts PasswordOptions
+		if _, err := flags.ParseArgs(&opts, strings.Fields(cost.Flags())); err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.Iterations != cost.Iterations || uint32(opts.Memory) != cost.Memory || opts.Lanes != cost.Lanes {
+			t.Fatalf("flags %q select %d iterations, %d KiB, %d lanes", cost.Flags(), opts.Iterations, opts.Memory, opts.Lanes)
+		}
+	}
+}
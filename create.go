@@ -7,38 +7,42 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/wg/arc/archive"
 )
 
 func (c *Cmd) Create(arc *archive.Writer, names ...string) error {
-	headers, errors := Scan(names)
+	headers, errors := Scan(c.Root, names)
 	for header := range headers {
 		name := header.Name
 		size := header.Size
 
-		err := arc.Add(header)
-		if err != nil {
-			return err
+		header.Name = c.store(name)
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = c.store(header.Linkname)
 		}
 
-		if header.Typeflag == tar.TypeReg {
-			r, err := os.Open(name)
-			if err != nil {
-				return err
-			}
+		if c.NumericOwner {
+			header.Uname = ""
+			header.Gname = ""
+		}
 
-			err = arc.Copy(r, size)
-			r.Close()
+		var err error
+		if header.Typeflag == tar.TypeReg {
+			err = add(arc, header, rooted(c.Root, name), size)
+		} else {
+			err = arc.Add(header)
+		}
 
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
 		}
 
 		if c.Verbose > 0 {
-			fmt.Println("a", name)
+			fmt.Fprintln(c.Log, "a", name)
 		}
 	}
 
@@ -50,12 +54,105 @@ func (c *Cmd) Create(arc *archive.Writer, names ...string) error {
 	}
 }
 
-func Scan(names []string) (<-chan *tar.Header, <-chan error) {
+// add adds a regular file, storing only the data regions of files with
+// holes.
+func add(arc *archive.Writer, header *tar.Header, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	regions, err := dataRegions(f, size)
+	switch {
+	case err != nil:
+		return err
+	case regions != nil:
+		return arc.AddSparse(header, regions, f)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := arc.Add(header); err != nil {
+		return err
+	}
+
+	return arc.Copy(f, size)
+}
+
+// store returns the name to store for a file, rewritten by each
+// Transform and then normalized unless absolute names are allowed.
+func (c *Cmd) store(name string) string {
+	for _, t := range c.Transforms {
+		name = t.Apply(name)
+	}
+
+	if c.Absolute {
+		return name
+	}
+
+	name, prefix := normalize(name)
+	if prefix != "" {
+		c.warnRemoved(prefix)
+	}
+
+	return name
+}
+
+// normalize returns the name with . and .. collapsed and any leading /
+// or ../ removed, along with the prefix removed.
+func normalize(name string) (string, string) {
+	name = path.Clean(name)
+
+	prefix := ""
+	switch {
+	case strings.HasPrefix(name, "/"):
+		prefix = "/"
+		name = strings.TrimLeft(name, "/")
+	case name == ".." || strings.HasPrefix(name, "../"):
+		prefix = "../"
+		for name == ".." || strings.HasPrefix(name, "../") {
+			name = strings.TrimPrefix(name[2:], "/")
+		}
+	}
+
+	if name == "" {
+		name = "."
+	}
+
+	return name, prefix
+}
+
+// rooted returns the path of the named file relative to root unless the
+// name is absolute.
+func rooted(root, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(root, name)
+}
+
+// A fileID is the device and inode number of a file.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// Scan sends a header for each of the named files and directories,
+// including the contents of directories, with names relative to root.
+// Headers include the owner and any xattrs and ACLs of each file.
+// Files with more than one hard link are sent as regular files the
+// first time they are seen and then as links to that name. Devices and
+// FIFOs are included, sockets are reported and skipped.
+func Scan(root string, names []string) (<-chan *tar.Header, <-chan error) {
 	headers := make(chan *tar.Header, 64)
 	errors := make(chan error)
 
 	go func() {
-		err := scan(names, headers)
+		links := map[fileID]string{}
+		err := scan(root, names, links, headers)
 		close(headers)
 		if err != nil {
 			errors <- err
@@ -65,9 +162,11 @@ func Scan(names []string) (<-chan *tar.Header, <-chan error) {
 	return headers, errors
 }
 
-func scan(names []string, headers chan<- *tar.Header) error {
+func scan(root string, names []string, links map[fileID]string, headers chan<- *tar.Header) error {
 	for _, name := range names {
-		info, err := os.Lstat(name)
+		path := rooted(root, name)
+
+		info, err := os.Lstat(path)
 		if err != nil {
 			return err
 		}
@@ -75,12 +174,19 @@ func scan(names []string, headers chan<- *tar.Header) error {
 		mode := info.Mode()
 		link := ""
 
-		if !mode.IsRegular() && mode&(os.ModeDir|os.ModeSymlink) == 0 {
+		switch {
+		case mode.IsRegular():
+		case mode&(os.ModeDir|os.ModeSymlink|os.ModeDevice|os.ModeNamedPipe) != 0:
+		case mode&os.ModeSocket != 0:
+			fmt.Fprintf(os.Stderr, "archive: %s: socket ignored\n", name)
+			continue
+		default:
+			fmt.Fprintf(os.Stderr, "archive: %s: unsupported file type ignored\n", name)
 			continue
 		}
 
 		if mode&os.ModeSymlink != 0 {
-			link, err = os.Readlink(name)
+			link, err = os.Readlink(path)
 			if err != nil {
 				return err
 			}
@@ -92,10 +198,27 @@ func scan(names []string, headers chan<- *tar.Header) error {
 		}
 
 		header.Name = name
+
+		if mode&os.ModeSymlink == 0 {
+			if err := readXattrs(path, header); err != nil {
+				return err
+			}
+		}
+
+		if id, ok := linkID(info); ok && mode.IsRegular() {
+			if first, ok := links[id]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				links[id] = name
+			}
+		}
+
 		headers <- header
 
 		if mode.IsDir() {
-			dir, err := os.Open(name)
+			dir, err := os.Open(path)
 			if err != nil {
 				return err
 			}
@@ -110,7 +233,7 @@ func scan(names []string, headers chan<- *tar.Header) error {
 					names[i] = filepath.Join(name, n)
 				}
 
-				err = scan(names, headers)
+				err = scan(root, names, links, headers)
 			}
 			dir.Close()
 
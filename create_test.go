@@ -0,0 +1,93 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanHardLinks(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	file := filepath.Join(root, "a")
+	if err := ioutil.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"b", "c"} {
+		if err := os.Link(file, filepath.Join(root, name)); err != nil {
+			t.Skip("hard links not supported", err)
+		}
+	}
+
+	headers := scanHeaders(t, root, "a", "b", "c")
+
+	switch {
+	case headers[0].Typeflag != tar.TypeReg || headers[0].Size != 4:
+		t.Fatal("first link not stored as regular file")
+	case headers[1].Typeflag != tar.TypeLink || headers[1].Linkname != "a":
+		t.Fatal("second link not stored as link")
+	case headers[2].Typeflag != tar.TypeLink || headers[2].Linkname != "a":
+		t.Fatal("third link not stored as link")
+	case headers[1].Size != 0 || headers[2].Size != 0:
+		t.Fatal("link stored with data")
+	}
+}
+
+func TestCreateRegularFile(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	data := bytes.Repeat([]byte("data"), 4096)
+	if err := ioutil.WriteFile(filepath.Join(root, "file"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+	w, err := arc.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cmd{Root: root, Log: ioutil.Discard}
+	if err := c.Create(w.Writer, "file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rewind(arc)
+
+	dest := filepath.Join(root, "dest")
+	if err := extractEntries(t, &Cmd{Root: dest}, arc); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "file")); err != nil || !bytes.Equal(b, data) {
+		t.Fatal("file content mismatch", err)
+	}
+}
+
+func scanHeaders(t *testing.T, root string, names ...string) []*tar.Header {
+	var result []*tar.Header
+
+	headers, errors := Scan(root, names)
+	for h := range headers {
+		result = append(result, h)
+	}
+
+	select {
+	case err := <-errors:
+		t.Fatal(err)
+	default:
+	}
+
+	return result
+}
@@ -0,0 +1,8 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+// mkdev returns the device number of a major and minor number.
+func mkdev(major, minor int64) int {
+	return int(major<<24 | minor&0xffffff)
+}
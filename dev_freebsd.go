@@ -0,0 +1,10 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+// mkdev returns the device number of a major and minor number.
+func mkdev(major, minor int64) uint64 {
+	dev := uint64(major&0xff)<<8 | uint64(major&^0xff)<<32
+	dev |= uint64(minor&0xff00)<<24 | uint64(minor&^0xff00)
+	return dev
+}
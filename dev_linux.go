@@ -0,0 +1,10 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+// mkdev returns the device number of a major and minor number.
+func mkdev(major, minor int64) int {
+	dev := (minor & 0xff) | (major&0xfff)<<8
+	dev |= (minor&^0xff)<<12 | (major&^0xfff)<<32
+	return int(dev)
+}
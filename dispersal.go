@@ -0,0 +1,204 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"io"
+
+	"github.com/wg/arc/ida"
+)
+
+// maxSubsets limits the number of subsets a dispersed archive is read
+// from.
+const maxSubsets = 256
+
+// A dispersal reads the encrypted data of a dispersed shard archive
+// from subsets of k shards. Any k shards recreate the data, so each
+// subset is a separate copy of it and a chunk that fails to authenticate
+// is read from the next subset instead. Subsets are ordered by how many
+// of the first k shards they replace, so a single damaged shard is
+// passed over after at most k * (n - k) subsets.
+type dispersal struct {
+	shards  []*ShardArchive
+	subsets [][]int
+}
+
+// A subset recreates the data from one subset of the shards, creating
+// its decoder on first read.
+type subset struct {
+	ids     []byte
+	readers []io.Reader
+	io.Reader
+}
+
+// An offsetReader reads a file shared by several subsets from its own
+// offset.
+type offsetReader struct {
+	io.ReadSeeker
+	offset int64
+}
+
+// newDispersal returns a dispersal of shards, which must have distinct
+// IDs, and one reader for each of its subsets. Shards that can't seek
+// are only read by the first subset.
+func newDispersal(shards []*ShardArchive, k int) (*dispersal, []io.Reader, error) {
+	d := &dispersal{shards: shards}
+
+	offsets := make([]int64, len(shards))
+	for i, shard := range shards {
+		f, ok := shard.File.(io.ReadSeeker)
+		if !ok {
+			offsets = nil
+			break
+		}
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, nil, err
+		}
+		offsets[i] = offset
+	}
+
+	if offsets == nil {
+		d.subsets = subsets(k, k)
+	} else {
+		d.subsets = subsets(len(shards), k)
+	}
+
+	readers := make([]io.Reader, len(d.subsets))
+	for i, indexes := range d.subsets {
+		s := &subset{}
+		for _, j := range indexes {
+			var r io.Reader = shards[j].File
+			if offsets != nil {
+				r = &offsetReader{shards[j].File.(io.ReadSeeker), offsets[j]}
+			}
+			s.ids = append(s.ids, shards[j].ID)
+			s.readers = append(s.readers, r)
+		}
+		readers[i] = s
+	}
+
+	return d, readers, nil
+}
+
+// damaged returns the shards found to be damaged given the indexes of
+// the subsets that failed to authenticate a chunk and of the subset the
+// last chunk was read from. A shard is damaged if it belongs to every
+// subset that failed but not the current subset or, when no shard does,
+// to any subset that failed but not the current subset.
+func (d *dispersal) damaged(failed []int, current int) []File {
+	if len(failed) == 0 {
+		return nil
+	}
+
+	counts := make([]int, len(d.shards))
+	for _, i := range failed {
+		for _, j := range d.subsets[i] {
+			counts[j]++
+		}
+	}
+
+	for _, j := range d.subsets[current] {
+		counts[j] = 0
+	}
+
+	var common, suspect []File
+	for j, n := range counts {
+		switch {
+		case n == len(failed):
+			common = append(common, d.shards[j].File)
+			fallthrough
+		case n > 0:
+			suspect = append(suspect, d.shards[j].File)
+		}
+	}
+
+	if len(common) > 0 {
+		return common
+	}
+	return suspect
+}
+
+func (s *subset) Read(b []byte) (int, error) {
+	if s.Reader == nil {
+		r, err := ida.NewReader(s.ids, s.readers)
+		if err != nil {
+			return 0, err
+		}
+		s.Reader = r
+	}
+	return s.Reader.Read(b)
+}
+
+func (r *offsetReader) Read(b []byte) (int, error) {
+	if _, err := r.Seek(r.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := r.ReadSeeker.Read(b)
+	r.offset += int64(n)
+	return n, err
+}
+
+// subsets returns up to maxSubsets k-subsets of the indexes 0 to n-1,
+// beginning with the first k and followed by those replacing one of the
+// first k, then two, and so on.
+func subsets(n, k int) [][]int {
+	var subsets [][]int
+
+	for d := 0; d <= k && d <= n-k; d++ {
+		complete := combinations(k, d, func(drop []int) bool {
+			return combinations(n-k, d, func(add []int) bool {
+				subset := make([]int, 0, k)
+				for i, j := 0, 0; i < k; i++ {
+					if j < len(drop) && drop[j] == i {
+						j++
+						continue
+					}
+					subset = append(subset, i)
+				}
+				for _, i := range add {
+					subset = append(subset, k+i)
+				}
+				subsets = append(subsets, subset)
+				return len(subsets) < maxSubsets
+			})
+		})
+
+		if !complete {
+			break
+		}
+	}
+
+	return subsets
+}
+
+// combinations calls f with each k-combination of the indexes 0 to n-1
+// in lexical order until f returns false, and reports whether f was
+// called with every combination.
+func combinations(n, k int, f func([]int) bool) bool {
+	c := make([]int, k)
+	for i := range c {
+		c[i] = i
+	}
+
+	for {
+		if !f(c) {
+			return false
+		}
+
+		i := k - 1
+		for i >= 0 && c[i] == n-k+i {
+			i--
+		}
+
+		if i < 0 {
+			return true
+		}
+
+		c[i]++
+		for j := i + 1; j < k; j++ {
+			c[j] = c[j-1] + 1
+		}
+	}
+}
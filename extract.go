@@ -4,17 +4,45 @@ package main
 
 import (
 	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/wg/arc/archive"
 )
 
+var (
+	ErrUnsafePath  = errors.New("archive: path outside destination")
+	ErrSymlinkPath = errors.New("archive: path through symlink")
+	ErrLinkTarget  = errors.New("archive: link target not extracted")
+	ErrRejected    = errors.New("archive: entries rejected")
+	ErrNotCreated  = errors.New("archive: devices or FIFOs not created")
+)
+
+// Extract extracts each entry of the archive. Directories are created
+// writable by their owner and given their stored mode and mtime once
+// every entry has been extracted, deepest first, so that entries may be
+// extracted into directories stored without write permission. Existing
+// directories are given the same mode and mtime, but files left in
+// place are not touched. When transactional, entries are staged and
+// only moved into place once the archive is verified.
 func (c *Cmd) Extract(arc *RegexFilter) error {
 	mtimes := map[string]time.Time{}
+	dirs := []*directory{}
+	rejected := 0
+	failed := 0
+
+	if c.Transactional {
+		if err := c.begin(); err != nil {
+			return err
+		}
+		defer c.rollback()
+	}
 
 	for arc.Next() {
 		h := arc.Header
@@ -22,20 +50,41 @@ func (c *Cmd) Extract(arc *RegexFilter) error {
 		name := h.Name
 		mode := os.FileMode(h.Mode)
 
-		var err error
-		switch h.Typeflag {
-		case tar.TypeReg, tar.TypeRegA:
-			err = extract(name, mode, h.Size, arc)
-		case tar.TypeDir:
-			err = os.Mkdir(name, mode)
-		case tar.TypeSymlink:
-			err = os.Symlink(h.Linkname, name)
+		path, ok, err := c.path(name)
+		if ok && err == nil {
+			err = c.create(path, h, func(path string) error {
+				switch h.Typeflag {
+				case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+					return extract(path, mode, h.Size, archive.Sparse(h), arc)
+				case tar.TypeDir:
+					return mkdir(path)
+				case tar.TypeSymlink:
+					return symlink(h.Linkname, path)
+				case tar.TypeLink:
+					return c.link(h.Linkname, path)
+				case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+					return mkspecial(path, h)
+				}
+				return nil
+			})
 		}
 
 		var action string
 		switch {
+		case !ok:
+			continue
+		case err == ErrUnsafePath || err == ErrSymlinkPath || err == ErrLinkTarget:
+			fmt.Fprintln(os.Stderr, err, name)
+			rejected++
+			continue
+		case os.IsExist(err) && h.Typeflag == tar.TypeDir && isDir(c.staged(path)):
+			action = "x"
 		case os.IsExist(err):
 			action = "-"
+		case err != nil && special(h):
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
 		case err != nil:
 			return err
 		default:
@@ -46,7 +95,21 @@ func (c *Cmd) Extract(arc *RegexFilter) error {
 			fmt.Println(action, name)
 		}
 
-		mtimes[name] = h.ModTime
+		if action != "x" {
+			continue
+		}
+
+		if h.Typeflag != tar.TypeLink {
+			c.restore(c.staged(path), h)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			dirs = append(dirs, &directory{path, h})
+		case tar.TypeSymlink, tar.TypeLink:
+		default:
+			mtimes[path] = h.ModTime
+		}
 	}
 
 	switch {
@@ -56,19 +119,203 @@ func (c *Cmd) Extract(arc *RegexFilter) error {
 		return ErrVerifyFailed
 	}
 
+	if c.stage != "" {
+		if err := c.commit(); err != nil {
+			return err
+		}
+	}
+
 	ctime := time.Now()
-	for name, mtime := range mtimes {
-		err := os.Chtimes(name, ctime, mtime)
+	for path, mtime := range mtimes {
+		err := os.Chtimes(path, ctime, mtime)
 		if err != nil {
 			return err
 		}
 	}
 
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return dirs[i].depth() > dirs[j].depth()
+	})
+
+	for _, dir := range dirs {
+		if err := dir.fixup(ctime); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case rejected > 0:
+		return ErrRejected
+	case failed > 0:
+		return ErrNotCreated
+	}
+
+	return nil
+}
+
+// A directory is an extracted directory and its header.
+type directory struct {
+	path   string
+	header *tar.Header
+}
+
+func (d *directory) depth() int {
+	return strings.Count(filepath.Clean(d.path), string(filepath.Separator))
+}
+
+// fixup gives the directory its stored mode and mtime.
+func (d *directory) fixup(atime time.Time) error {
+	mode := os.FileMode(d.header.Mode).Perm() | setid(d.header.Mode)
+	if err := os.Chmod(d.path, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(d.path, atime, d.header.ModTime)
+}
+
+// restore gives an extracted entry its stored owner when extracting
+// with the same owner, and its stored ACLs and user xattrs, or every
+// xattr with AllXattrs. Failures are reported but do not stop
+// extraction.
+func (c *Cmd) restore(path string, h *tar.Header) {
+	if c.SameOwner {
+		if err := c.chown(path, h); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if h.Typeflag != tar.TypeSymlink {
+		if err := writeXattrs(path, h, c.AllXattrs); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		}
+	}
+}
+
+// path returns the path to extract the named entry to and reports
+// whether the entry is extracted at all.
+func (c *Cmd) path(name string) (string, bool, error) {
+	name, ok := c.rename(name)
+	if !ok {
+		return "", false, nil
+	}
+
+	path, err := c.resolve(name)
+	return path, true, err
+}
+
+// rename returns the name of an entry after applying --strip-components
+// and --transform, and reports whether the entry is extracted at all.
+func (c *Cmd) rename(name string) (string, bool) {
+	name, ok := strip(name, c.Strip)
+	if !ok {
+		return "", false
+	}
+
+	for _, t := range c.Transforms {
+		name = t.Apply(name)
+	}
+
+	return name, true
+}
+
+// strip removes the first n components of the name and reports whether
+// any remain.
+func strip(name string, n int) (string, bool) {
+	if n == 0 {
+		return name, true
+	}
+
+	abs := strings.HasPrefix(name, "/")
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	if len(parts) <= n {
+		return "", false
+	}
+
+	name = strings.Join(parts[n:], "/")
+	if abs {
+		name = "/" + name
+	}
+
+	return name, true
+}
+
+// resolve returns the path to extract the named entry to under the
+// destination root. Leading slashes are removed from absolute names and
+// names outside the root or whose parent directories include a symlink
+// are rejected, unless absolute names are allowed in which case names
+// are used as they are. Parent directories in the staging directory of
+// a transaction are checked too.
+func (c *Cmd) resolve(name string) (string, error) {
+	if c.Absolute {
+		return rooted(c.Root, name), nil
+	}
+
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(clean) {
+		clean = strings.TrimLeft(clean, string(filepath.Separator))
+		c.warnRemoved("/")
+	}
+
+	parts := strings.Split(clean, string(filepath.Separator))
+	if parts[0] == ".." {
+		return "", ErrUnsafePath
+	}
+
+	for _, root := range []string{c.Root, c.stage} {
+		if root == "" {
+			continue
+		}
+		if err := parents(root, parts[:len(parts)-1]); err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(c.Root, clean), nil
+}
+
+// parents checks that none of the directories under root named by parts
+// is a symlink.
+func parents(root string, parts []string) error {
+	dir := root
+	for _, part := range parts {
+		dir = filepath.Join(dir, part)
+		switch info, err := os.Lstat(dir); {
+		case os.IsNotExist(err):
+			return nil
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return ErrSymlinkPath
+		}
+	}
 	return nil
 }
 
-func extract(path string, mode os.FileMode, size int64, r io.Reader) error {
-	err := os.MkdirAll(filepath.Dir(path), 0)
+// link creates a hard link at path to the previously extracted entry
+// named linkname.
+func (c *Cmd) link(linkname, path string) error {
+	target, ok, err := c.path(linkname)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return ErrLinkTarget
+	}
+
+	if _, err := os.Lstat(c.staged(target)); err == nil {
+		target = c.staged(target)
+	} else if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return ErrLinkTarget
+	}
+
+	if err := mkparent(path); err != nil {
+		return err
+	}
+
+	return os.Link(target, path)
+}
+
+func extract(path string, mode os.FileMode, size int64, sparse bool, r io.Reader) error {
+	err := mkparent(path)
 	if err != nil {
 		return err
 	}
@@ -79,12 +326,107 @@ func extract(path string, mode os.FileMode, size int64, r io.Reader) error {
 	}
 	defer f.Close()
 
-	switch n, err := io.Copy(f, r); {
+	var w io.Writer = f
+	if sparse {
+		w = &sparseWriter{f}
+	}
+
+	switch n, err := io.Copy(w, r); {
 	case err != nil:
 		return err
 	case n < size:
 		return archive.ErrShortCopy
 	}
 
+	if sparse {
+		return f.Truncate(size)
+	}
+
 	return nil
 }
+
+// mkdir creates a directory writable by its owner, whose stored mode
+// is applied after its contents are extracted.
+func mkdir(path string) error {
+	if err := mkparent(path); err != nil {
+		return err
+	}
+	return os.Mkdir(path, 0700)
+}
+
+// symlink creates a symlink at path and any missing parent directories.
+func symlink(linkname, path string) error {
+	if err := mkparent(path); err != nil {
+		return err
+	}
+	return os.Symlink(linkname, path)
+}
+
+// isDir reports whether path is a directory rather than a file or a
+// symlink to a directory.
+func isDir(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
+
+// mkparent creates any missing parent directories of path, which are
+// given mode 0777 less the umask as with tar.
+func mkparent(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0777)
+}
+
+// mkspecial creates a device or FIFO.
+func mkspecial(path string, h *tar.Header) error {
+	if err := mkparent(path); err != nil {
+		return err
+	}
+	return mknod(path, h)
+}
+
+// special reports whether the header is for a device or FIFO.
+func special(h *tar.Header) bool {
+	switch h.Typeflag {
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+// A sparseWriter writes to a file, seeking past blocks of zeros rather
+// than writing them to leave holes in the file.
+type sparseWriter struct {
+	f *os.File
+}
+
+const holeSize = 4096
+
+func (w *sparseWriter) Write(b []byte) (int, error) {
+	for n := 0; n < len(b); {
+		block := b[n:]
+		if len(block) > holeSize {
+			block = block[:holeSize]
+		}
+
+		var err error
+		if zeros(block) {
+			_, err = w.f.Seek(int64(len(block)), io.SeekCurrent)
+		} else {
+			_, err = w.f.Write(block)
+		}
+
+		if err != nil {
+			return n, err
+		}
+		n += len(block)
+	}
+	return len(b), nil
+}
+
+func zeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
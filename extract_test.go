@@ -0,0 +1,442 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wg/arc/archive"
+)
+
+func TestExtractUnsafePaths(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	dest := filepath.Join(root, "dest")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{dest, outside} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "ok/file", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "ok/../../evil", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "/abs/file", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "link/file", Typeflag: tar.TypeReg, Mode: 0600},
+	})
+
+	c := &Cmd{Root: dest}
+	if err := extractEntries(t, c, arc); err != ErrRejected {
+		t.Fatal("expected rejected entries got", err)
+	}
+
+	for _, path := range []string{"dest/ok/file", "dest/abs/file", "dest/link"} {
+		if _, err := os.Lstat(filepath.Join(root, path)); err != nil {
+			t.Fatal("safe entry not extracted", path)
+		}
+	}
+
+	for _, path := range []string{"evil", "outside/file"} {
+		if _, err := os.Lstat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Fatal("unsafe entry extracted", path)
+		}
+	}
+}
+
+func TestExtractAbsoluteNames(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	dest := filepath.Join(root, "dest")
+	if err := os.Mkdir(dest, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	abs := filepath.Join(root, "abs")
+	arc := extractArchive(t, []*tar.Header{
+		{Name: abs, Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "../rel", Typeflag: tar.TypeReg, Mode: 0600},
+	})
+
+	c := &Cmd{Root: dest, Absolute: true}
+	if err := extractEntries(t, c, arc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{abs, filepath.Join(root, "rel")} {
+		if _, err := os.Lstat(path); err != nil {
+			t.Fatal("entry not extracted", path)
+		}
+	}
+}
+
+func TestExtractHardLinks(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "dir/b", Typeflag: tar.TypeLink, Linkname: "a"},
+		{Name: "c", Typeflag: tar.TypeLink, Linkname: "missing"},
+		{Name: "d", Typeflag: tar.TypeLink, Linkname: "../a"},
+	})
+
+	c := &Cmd{Root: root}
+	if err := extractEntries(t, c, arc); err != ErrRejected {
+		t.Fatal("expected rejected entries got", err)
+	}
+
+	a, err := os.Stat(filepath.Join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.Stat(filepath.Join(root, "dir", "b"))
+	if err != nil || !os.SameFile(a, b) {
+		t.Fatal("hard link not extracted")
+	}
+
+	for _, name := range []string{"c", "d"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Fatal("invalid hard link extracted", name)
+		}
+	}
+}
+
+func TestExtractNestedSymlink(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "sub/f", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "sub/l", Typeflag: tar.TypeSymlink, Linkname: "f"},
+	})
+
+	r, err := arc.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRegexFilter(r.Reader, "^sub/l$")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cmd{Root: root}
+	if err := c.Extract(f); err != nil {
+		t.Fatal(err)
+	}
+
+	switch link, err := os.Readlink(filepath.Join(root, "sub", "l")); {
+	case err != nil:
+		t.Fatal("nested symlink not extracted", err)
+	case link != "f":
+		t.Fatal("unexpected symlink target", link)
+	}
+}
+
+func TestExtractDirectories(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	mtime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "ro", Typeflag: tar.TypeDir, Mode: 0555, ModTime: mtime},
+		{Name: "ro/sub", Typeflag: tar.TypeDir, Mode: 0500, ModTime: mtime},
+		{Name: "ro/sub/file", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "implicit/file", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "late/file", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "late", Typeflag: tar.TypeDir, Mode: 0705, ModTime: mtime},
+		{Name: "tmp", Typeflag: tar.TypeDir, Mode: 01777, ModTime: mtime},
+	})
+
+	if err := extractEntries(t, &Cmd{Root: root}, arc); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(root, "ro"), 0700)
+	defer os.Chmod(filepath.Join(root, "ro/sub"), 0700)
+
+	dirs := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"ro", 0555},
+		{"ro/sub", 0500},
+		{"late", 0705},
+		{"tmp", 0777 | os.ModeSticky},
+	}
+
+	for _, dir := range dirs {
+		info, err := os.Stat(filepath.Join(root, dir.name))
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case info.Mode() != os.ModeDir|dir.mode:
+			t.Fatalf("directory %s has mode %s", dir.name, info.Mode())
+		case !info.ModTime().Equal(mtime):
+			t.Fatalf("directory %s has mtime %s", dir.name, info.ModTime())
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(root, "implicit"))
+	if err != nil || info.Mode().Perm()&0700 != 0700 {
+		t.Fatal("implicit directory not accessible")
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "ro/sub/file")); err != nil {
+		t.Fatal("file in read-only directory not extracted")
+	}
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	old := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	mtime := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		cmd     Cmd
+		mtime   time.Time
+		content string
+		backups []string
+	}{
+		{Cmd{}, old, "old", nil},
+		{Cmd{Policy: SkipOld}, old, "old", nil},
+		{Cmd{Policy: Overwrite}, mtime, "", nil},
+		{Cmd{Policy: KeepNewer}, mtime, "old", nil},
+		{Cmd{Policy: KeepNewer}, old, "", nil},
+		{Cmd{Policy: Overwrite, Backup: SimpleBackup}, mtime, "", []string{"a~"}},
+		{Cmd{Policy: Overwrite, Backup: NumberedBackup}, mtime, "", []string{"a.~1~"}},
+	}
+
+	for i, test := range tests {
+		dir := filepath.Join(root, strconv.Itoa(i))
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		file := filepath.Join(dir, "a")
+		if err := ioutil.WriteFile(file, []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, test.mtime, test.mtime)
+
+		arc := extractArchive(t, []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeReg, Mode: 0600, ModTime: old.Add(time.Hour)},
+		})
+
+		c := test.cmd
+		c.Root = dir
+		if err := extractEntries(t, &c, arc); err != nil {
+			t.Fatal(err)
+		}
+
+		if b, err := ioutil.ReadFile(file); err != nil || string(b) != test.content {
+			t.Fatalf("test %d extracted %q, %v", i, b, err)
+		}
+
+		mtime := old.Add(time.Hour)
+		if test.content == "old" {
+			mtime = test.mtime
+		}
+
+		if info, err := os.Stat(file); err != nil || !info.ModTime().Equal(mtime) {
+			t.Fatalf("test %d left mtime %v, expected %v", i, info.ModTime(), mtime)
+		}
+
+		names, err := ioutil.ReadDir(dir)
+		if err != nil || len(names) != 1+len(test.backups) {
+			t.Fatalf("test %d left %d files", i, len(names))
+		}
+
+		for _, name := range test.backups {
+			if b, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != "old" {
+				t.Fatalf("test %d backup %s not created", i, name)
+			}
+		}
+	}
+}
+
+func TestNumberedBackups(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"a", "a.~1~", "a.~9~", "a.~x~"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Cmd{Backup: NumberedBackup}
+	if err := c.backup(filepath.Join(root, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a.~10~")); err != nil {
+		t.Fatal("numbered backup not created", err)
+	}
+}
+
+func TestTransactionalExtract(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "d", "old"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "d", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "d/new", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "x/file", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "link", Typeflag: tar.TypeLink, Linkname: "x/file"},
+	})
+
+	c := &Cmd{Root: root, Transactional: true}
+	if err := extractEntries(t, c, arc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"d/old", "d/new", "x/file"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); err != nil {
+			t.Fatal("entry not in place", name)
+		}
+	}
+
+	a, _ := os.Stat(filepath.Join(root, "x/file"))
+	b, err := os.Stat(filepath.Join(root, "link"))
+	if err != nil || !os.SameFile(a, b) {
+		t.Fatal("hard link to staged entry not in place")
+	}
+
+	if names, _ := ioutil.ReadDir(root); len(names) != 3 {
+		t.Fatal("staging directory not removed")
+	}
+}
+
+func TestTransactionalRollback(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	data := make([]byte, 3*archive.ChunkSize)
+	rand.Read(data)
+
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+
+	w, err := arc.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		h := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data))}
+		if err := w.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Copy(bytes.NewReader(data), h.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.buffer[len(buf.buffer)-1] ^= 0xff
+	rewind(arc)
+
+	c := &Cmd{Root: root, Transactional: true}
+	if err := extractEntries(t, c, arc); err == nil {
+		t.Fatal("extracted corrupt archive")
+	}
+
+	if names, _ := ioutil.ReadDir(root); len(names) != 0 {
+		t.Fatal("entries left after rollback", len(names))
+	}
+}
+
+func TestStripComponents(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		strip string
+		ok    bool
+	}{
+		{"home/alice/project/file", 2, "project/file", true},
+		{"home/alice/", 2, "", false},
+		{"./home/alice", 1, "home/alice", true},
+		{"/home/alice/file", 1, "/alice/file", true},
+		{"home//alice", 1, "alice", true},
+		{"file", 0, "file", true},
+	}
+
+	for _, test := range tests {
+		if strip, ok := strip(test.name, test.n); strip != test.strip || ok != test.ok {
+			t.Fatalf("strip %d from %s got %q, %v", test.n, test.name, strip, ok)
+		}
+	}
+}
+
+func extractArchive(t *testing.T, headers []*tar.Header) Archiver {
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+
+	w, err := arc.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range headers {
+		if err := w.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		data := bytes.Repeat([]byte(h.Name), int(h.Size))
+		if err := w.Copy(bytes.NewReader(data[:h.Size]), h.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rewind(arc)
+
+	return arc
+}
+
+func extractEntries(t *testing.T, c *Cmd, arc Archiver) error {
+	r, err := arc.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRegexFilter(r.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.Extract(f)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "arc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package ida
+
+// Arithmetic in GF(2^8) with the reducing polynomial x^8+x^4+x^3+x^2+1
+// and generator 2, using exponent and logarithm tables.
+
+var (
+	exp [510]byte
+	log [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return exp[int(log[a])+int(log[b])]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return exp[int(log[a])+255-int(log[b])]
+}
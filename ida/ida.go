@@ -0,0 +1,192 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+// Package ida implements Rabin's information dispersal algorithm over
+// GF(2^8). Data is split into n pieces, each 1/k the size of the data,
+// such that any k pieces recreate it.
+//
+// Each piece has a distinct non-zero ID x and byte j of the piece is
+// the k-byte segment j of the data evaluated as a polynomial at x, so
+// the pieces are the product of a Vandermonde matrix and the data.
+package ida
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidID        = errors.New("ida: invalid piece ID")
+	ErrInvalidThreshold = errors.New("ida: invalid threshold")
+	ErrInvalidLength    = errors.New("ida: invalid length")
+)
+
+// An Encoder splits data into pieces.
+type Encoder struct {
+	k    int
+	rows [][]byte
+}
+
+// A Decoder recreates data from k pieces.
+type Decoder struct {
+	k    int
+	rows [][]byte
+}
+
+// NewEncoder returns an Encoder that splits data into one piece for
+// each ID, any k of which recreate the data.
+func NewEncoder(k int, ids []byte) (*Encoder, error) {
+	if k < 1 || k > len(ids) {
+		return nil, ErrInvalidThreshold
+	}
+
+	rows, err := vandermonde(k, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encoder{k: k, rows: rows}, nil
+}
+
+// Encode splits data into pieces, one for each ID, with pieces[i] the
+// piece for ids[i]. The length of data must be a multiple of k and each
+// piece must have length len(data)/k.
+func (e *Encoder) Encode(data []byte, pieces [][]byte) error {
+	return transform(e.k, e.rows, data, pieces)
+}
+
+// NewDecoder returns a Decoder that recreates data from the pieces with
+// the given IDs. k is the number of IDs.
+func NewDecoder(ids []byte) (*Decoder, error) {
+	k := len(ids)
+	if k < 1 {
+		return nil, ErrInvalidThreshold
+	}
+
+	rows, err := vandermonde(k, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoder{k: k, rows: invert(rows)}, nil
+}
+
+// Decode recreates data from pieces, with pieces[i] the piece for
+// ids[i]. All pieces must have the same length and data must have
+// length k times that.
+func (d *Decoder) Decode(pieces [][]byte, data []byte) error {
+	if len(pieces) != d.k {
+		return ErrInvalidLength
+	}
+
+	for i := range pieces {
+		if len(pieces[i]) != len(pieces[0]) || len(data) != d.k*len(pieces[0]) {
+			return ErrInvalidLength
+		}
+	}
+
+	segment := make([]byte, d.k)
+	for j := range pieces[0] {
+		for i, piece := range pieces {
+			segment[i] = piece[j]
+		}
+		for i, row := range d.rows {
+			data[j*d.k+i] = dot(row, segment)
+		}
+	}
+
+	return nil
+}
+
+// transform multiplies each k-byte segment of data by the matrix rows,
+// writing the i-th result of segment j to pieces[i][j].
+func transform(k int, rows [][]byte, data []byte, pieces [][]byte) error {
+	if len(data)%k != 0 || len(pieces) != len(rows) {
+		return ErrInvalidLength
+	}
+
+	m := len(data) / k
+	for i := range pieces {
+		if len(pieces[i]) != m {
+			return ErrInvalidLength
+		}
+	}
+
+	for j := 0; j < m; j++ {
+		segment := data[j*k : (j+1)*k]
+		for i, row := range rows {
+			pieces[i][j] = dot(row, segment)
+		}
+	}
+
+	return nil
+}
+
+// vandermonde returns the rows (1, x, x^2, ..., x^(k-1)) for each ID x.
+func vandermonde(k int, ids []byte) ([][]byte, error) {
+	seen := [256]bool{}
+	rows := make([][]byte, len(ids))
+
+	for i, x := range ids {
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidID
+		}
+		seen[x] = true
+
+		rows[i] = make([]byte, k)
+		rows[i][0] = 1
+		for j := 1; j < k; j++ {
+			rows[i][j] = mul(rows[i][j-1], x)
+		}
+	}
+
+	return rows, nil
+}
+
+// invert returns the inverse of the square matrix m, which must be
+// invertible as any Vandermonde matrix with distinct IDs is.
+func invert(m [][]byte) [][]byte {
+	k := len(m)
+
+	a := make([][]byte, k)
+	inv := make([][]byte, k)
+	for i := range m {
+		a[i] = append([]byte{}, m[i]...)
+		inv[i] = make([]byte, k)
+		inv[i][i] = 1
+	}
+
+	for c := 0; c < k; c++ {
+		p := c
+		for a[p][c] == 0 {
+			p++
+		}
+		a[c], a[p] = a[p], a[c]
+		inv[c], inv[p] = inv[p], inv[c]
+
+		scale := div(1, a[c][c])
+		for j := 0; j < k; j++ {
+			a[c][j] = mul(a[c][j], scale)
+			inv[c][j] = mul(inv[c][j], scale)
+		}
+
+		for r := 0; r < k; r++ {
+			if r == c || a[r][c] == 0 {
+				continue
+			}
+			f := a[r][c]
+			for j := 0; j < k; j++ {
+				a[r][j] ^= mul(f, a[c][j])
+				inv[r][j] ^= mul(f, inv[c][j])
+			}
+		}
+	}
+
+	return inv
+}
+
+func dot(row, segment []byte) byte {
+	var sum byte
+	for i, v := range segment {
+		sum ^= mul(row[i], v)
+	}
+	return sum
+}
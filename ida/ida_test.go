@@ -0,0 +1,147 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package ida
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	ids := []byte{1, 2, 3, 4, 5}
+	k := 3
+
+	data := make([]byte, k*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	pieces := encode(t, k, ids, data)
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {3, 4, 2}} {
+		sub := make([][]byte, k)
+		sids := make([]byte, k)
+		for i, j := range subset {
+			sub[i] = pieces[j]
+			sids[i] = ids[j]
+		}
+
+		d, err := NewDecoder(sids)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := make([]byte, len(data))
+		if err := d.Decode(sub, out); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, out) {
+			t.Fatalf("decoding from pieces %v failed", subset)
+		}
+	}
+}
+
+func TestPieceSize(t *testing.T) {
+	data := make([]byte, 4*100)
+	for _, piece := range encode(t, 4, []byte{7, 9, 200, 255, 1, 2}, data) {
+		if len(piece) != 100 {
+			t.Fatalf("expected piece size %d got %d", 100, len(piece))
+		}
+	}
+}
+
+func TestAllIDs(t *testing.T) {
+	ids := make([]byte, 255)
+	for i := range ids {
+		ids[i] = byte(i + 1)
+	}
+
+	data := make([]byte, 255*4)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	pieces := encode(t, 255, ids, data)
+
+	d, err := NewDecoder(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, len(data))
+	if err := d.Decode(pieces, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, out) {
+		t.Fatal("decoding from all pieces failed")
+	}
+}
+
+func TestCorruptPiece(t *testing.T) {
+	ids := []byte{1, 2, 3}
+	data := make([]byte, 2*64)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	pieces := encode(t, 2, ids, data)
+	pieces[1][0] ^= 0xff
+
+	d, err := NewDecoder(ids[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, len(data))
+	if err := d.Decode(pieces[:2], out); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(data, out) {
+		t.Fatal("corrupt piece decoded to original data")
+	}
+}
+
+func TestInvalidArguments(t *testing.T) {
+	switch {
+	case invalidEncoder(0, []byte{1, 2}) != ErrInvalidThreshold:
+		t.Fatal("accepted threshold of 0")
+	case invalidEncoder(3, []byte{1, 2}) != ErrInvalidThreshold:
+		t.Fatal("accepted threshold greater than number of pieces")
+	case invalidEncoder(2, []byte{1, 1}) != ErrInvalidID:
+		t.Fatal("accepted duplicate ID")
+	case invalidEncoder(2, []byte{0, 1}) != ErrInvalidID:
+		t.Fatal("accepted zero ID")
+	}
+
+	e, _ := NewEncoder(2, []byte{1, 2})
+	if err := e.Encode(make([]byte, 3), [][]byte{{0}, {0}}); err != ErrInvalidLength {
+		t.Fatal("accepted data length not a multiple of threshold")
+	}
+}
+
+func encode(t *testing.T, k int, ids []byte, data []byte) [][]byte {
+	e, err := NewEncoder(k, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pieces := make([][]byte, len(ids))
+	for i := range pieces {
+		pieces[i] = make([]byte, len(data)/k)
+	}
+
+	if err := e.Encode(data, pieces); err != nil {
+		t.Fatal(err)
+	}
+
+	return pieces
+}
+
+func invalidEncoder(k int, ids []byte) error {
+	_, err := NewEncoder(k, ids)
+	return err
+}
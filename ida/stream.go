@@ -0,0 +1,195 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package ida
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// StripeSize is the number of bytes of each piece in a stripe. A stream
+// is dispersed as a sequence of stripes of k * StripeSize bytes, except
+// for the last which is padded with a 0x80 byte and as many zero bytes
+// as needed to make its length a multiple of k.
+const StripeSize = 16 * 1024
+
+var (
+	ErrInvalidPadding = errors.New("ida: invalid padding")
+)
+
+// A Writer disperses a stream into pieces written to one io.Writer per
+// piece ID.
+type Writer struct {
+	encoder *Encoder
+	k       int
+	data    []byte
+	pieces  [][]byte
+	writers []io.Writer
+}
+
+// A Reader recreates a stream from k pieces read from one io.Reader per
+// piece ID.
+type Reader struct {
+	decoder *Decoder
+	k       int
+	data    []byte
+	pending []byte
+	pieces  [][]byte
+	readers []*bufio.Reader
+	err     error
+}
+
+func NewWriter(k int, ids []byte, writers []io.Writer) (*Writer, error) {
+	encoder, err := NewEncoder(k, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	pieces := make([][]byte, len(ids))
+	for i := range pieces {
+		pieces[i] = make([]byte, StripeSize)
+	}
+
+	return &Writer{
+		encoder: encoder,
+		k:       k,
+		data:    make([]byte, 0, k*StripeSize),
+		pieces:  pieces,
+		writers: writers,
+	}, nil
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		m := copy(w.data[len(w.data):cap(w.data)], b)
+		w.data = w.data[:len(w.data)+m]
+		b = b[m:]
+		n += m
+
+		if len(w.data) == cap(w.data) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close pads and writes the final stripe. It does not close the
+// underlying writers.
+func (w *Writer) Close() error {
+	w.data = append(w.data, 0x80)
+	for len(w.data)%w.k != 0 {
+		w.data = append(w.data, 0)
+	}
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	size := len(w.data) / w.k
+	pieces := make([][]byte, len(w.pieces))
+	for i := range pieces {
+		pieces[i] = w.pieces[i][:size]
+	}
+
+	if err := w.encoder.Encode(w.data, pieces); err != nil {
+		return err
+	}
+
+	for i, piece := range pieces {
+		if _, err := w.writers[i].Write(piece); err != nil {
+			return err
+		}
+	}
+
+	w.data = w.data[:0]
+
+	return nil
+}
+
+func NewReader(ids []byte, readers []io.Reader) (*Reader, error) {
+	decoder, err := NewDecoder(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	k := len(ids)
+	r := &Reader{
+		decoder: decoder,
+		k:       k,
+		data:    make([]byte, k*StripeSize),
+		pieces:  make([][]byte, k),
+		readers: make([]*bufio.Reader, k),
+	}
+
+	for i := range readers {
+		r.pieces[i] = make([]byte, StripeSize)
+		r.readers[i] = bufio.NewReader(readers[i])
+	}
+
+	return r, nil
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.fill()
+	}
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// fill reads and decodes the next stripe, returning io.EOF once the
+// final stripe has been decoded.
+func (r *Reader) fill() error {
+	size := -1
+	for i, reader := range r.readers {
+		n, err := io.ReadFull(reader, r.pieces[i])
+		switch {
+		case err != nil && err != io.EOF && err != io.ErrUnexpectedEOF:
+			return err
+		case size != -1 && n != size:
+			return ErrInvalidLength
+		}
+		size = n
+	}
+
+	final := size < StripeSize
+	if !final {
+		_, err := r.readers[0].Peek(1)
+		final = err == io.EOF
+	}
+
+	pieces := make([][]byte, r.k)
+	for i := range pieces {
+		pieces[i] = r.pieces[i][:size]
+	}
+
+	data := r.data[:r.k*size]
+	if err := r.decoder.Decode(pieces, data); err != nil {
+		return err
+	}
+
+	if !final {
+		r.pending = data
+		return nil
+	}
+
+	end := len(data) - 1
+	for end >= 0 && data[end] == 0 {
+		end--
+	}
+
+	if end < 0 || data[end] != 0x80 {
+		return ErrInvalidPadding
+	}
+
+	r.pending = data[:end]
+
+	return io.EOF
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package ida
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	ids := []byte{1, 2, 3, 4}
+	k := 3
+
+	for _, size := range []int{0, 1, k - 1, k, k*StripeSize - 1, k * StripeSize, k*StripeSize + 1, 3*k*StripeSize + 7} {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+
+		bufs := make([]*bytes.Buffer, len(ids))
+		writers := make([]io.Writer, len(ids))
+		for i := range bufs {
+			bufs[i] = &bytes.Buffer{}
+			writers[i] = bufs[i]
+		}
+
+		w, err := NewWriter(k, ids, writers)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := bufs[0].Len(); n > size/k+StripeSize {
+			t.Fatalf("piece of %d bytes for %d bytes of data", n, size)
+		}
+
+		readers := []io.Reader{bufs[3], bufs[1], bufs[0]}
+		r, err := NewReader([]byte{ids[3], ids[1], ids[0]}, readers)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch out, err := ioutil.ReadAll(r); {
+		case err != nil:
+			t.Fatal(err)
+		case !bytes.Equal(data, out):
+			t.Fatalf("stream of %d bytes recreated incorrectly", size)
+		}
+	}
+}
+
+func TestTruncatedStream(t *testing.T) {
+	ids := []byte{1, 2}
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+
+	w, _ := NewWriter(2, ids, []io.Writer{a, b})
+	w.Write(make([]byte, 1000))
+	w.Close()
+
+	a.Truncate(a.Len() - 1)
+
+	r, _ := NewReader(ids, []io.Reader{a, b})
+	if _, err := ioutil.ReadAll(r); err != ErrInvalidLength {
+		t.Fatal("expected invalid length got", err)
+	}
+}
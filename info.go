@@ -0,0 +1,334 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wg/arc/argon2"
+)
+
+// Info describes an archive header, which is read without a key. Variant
+// is the Argon2 variant and Memory its memory use in KiB.
+type Info struct {
+	File       string     `json:"file"`
+	Size       int64      `json:"size,omitempty"`
+	Version    byte       `json:"version"`
+	Type       string     `json:"type"`
+	Signed     bool       `json:"signed"`
+	Dispersed  bool       `json:"dispersed,omitempty"`
+	Variant    string     `json:"variant,omitempty"`
+	Iterations uint32     `json:"iterations,omitempty"`
+	Memory     uint32     `json:"memory,omitempty"`
+	Lanes      byte       `json:"lanes,omitempty"`
+	Salt       string     `json:"salt,omitempty"`
+	Ephemeral  string     `json:"ephemeral,omitempty"`
+	Recipients int        `json:"recipients,omitempty"`
+	Set        string     `json:"set,omitempty"`
+	Shard      byte       `json:"shard,omitempty"`
+	Threshold  byte       `json:"threshold,omitempty"`
+	Shards     byte       `json:"shards,omitempty"`
+	Slots      []SlotInfo `json:"slots,omitempty"`
+}
+
+// SlotInfo describes a non-empty key slot.
+type SlotInfo struct {
+	Slot       int    `json:"slot"`
+	Type       string `json:"type"`
+	Variant    string `json:"variant,omitempty"`
+	Iterations uint32 `json:"iterations,omitempty"`
+	Memory     uint32 `json:"memory,omitempty"`
+	Lanes      byte   `json:"lanes,omitempty"`
+	Salt       string `json:"salt,omitempty"`
+	Ephemeral  string `json:"ephemeral,omitempty"`
+}
+
+func (c *Cmd) Info(paths ...string) error {
+	infos := make([]*Info, len(paths))
+
+	for i, path := range paths {
+		info, err := fileInfo(path)
+		if err != nil {
+			return fmt.Errorf("file %s: %s", path, err)
+		}
+		infos[i] = info
+	}
+
+	if c.JSON {
+		b, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(info)
+	}
+
+	return nil
+}
+
+func fileInfo(path string) (*Info, error) {
+	file, err := OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := ReadInfo(file)
+	if err != nil {
+		return nil, err
+	}
+	info.File = path
+
+	if f, ok := file.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && stat.Mode().IsRegular() {
+			info.Size = stat.Size()
+		}
+	}
+
+	return info, nil
+}
+
+// ReadInfo reads an archive header and its digest from r. Only the
+// version is read from a header of any version other than Version and
+// LegacyVersion.
+func ReadInfo(r io.Reader) (*Info, error) {
+	var vt [2]byte
+
+	if _, err := io.ReadFull(r, vt[:]); err != nil {
+		return nil, err
+	}
+
+	r = io.MultiReader(bytes.NewReader(vt[:]), r)
+
+	switch vt[0] {
+	case Version:
+	case LegacyVersion:
+		return readLegacyInfo(r, vt[1])
+	default:
+		return &Info{Version: vt[0]}, nil
+	}
+
+	var info *Info
+	var header []byte
+	var err error
+
+	switch vt[1] {
+	case Password:
+		a := &PasswordArchive{}
+		if header, err = readHeader(r, a); err != nil {
+			return nil, err
+		}
+		info = &Info{
+			Type:       "password",
+			Signed:     a.Flags&Signed != 0,
+			Variant:    argon2.Variant(a.Variant).String(),
+			Iterations: a.Iterations,
+			Memory:     a.Memory,
+			Lanes:      a.Lanes,
+			Salt:       hex.EncodeToString(a.Salt[:]),
+		}
+	case Curve448:
+		a := &Curve448Archive{}
+		if header, err = readHeader(r, a); err != nil {
+			return nil, err
+		}
+		for i := 0; i < int(a.Count); i++ {
+			b, err := readHeader(r, &Recipient{})
+			if err != nil {
+				return nil, err
+			}
+			header = append(header, b...)
+		}
+		info = &Info{
+			Type:       "curve448",
+			Signed:     a.Flags&Signed != 0,
+			Ephemeral:  hex.EncodeToString(a.Ephemeral[:]),
+			Recipients: int(a.Count),
+		}
+	case Shard:
+		a := &ShardArchive{}
+		if header, err = readHeader(r, a); err != nil {
+			return nil, err
+		}
+		info = &Info{
+			Type:      "shard",
+			Signed:    a.Flags&Signed != 0,
+			Dispersed: a.Flags&Dispersed != 0,
+			Set:       hex.EncodeToString(a.Set[:]),
+			Shard:     a.ID,
+			Threshold: a.Threshold,
+			Shards:    a.Count,
+		}
+	case KeySlots:
+		a := &KeySlotArchive{}
+		if header, err = readHeader(r, a); err != nil {
+			return nil, err
+		}
+		info = &Info{
+			Type:   "keyslots",
+			Signed: a.Flags&Signed != 0,
+		}
+		for i := range a.Slots {
+			b, err := readHeader(r, &a.Slots[i])
+			if err != nil {
+				return nil, err
+			}
+			header = append(header, b...)
+			if s := slotInfo(i, &a.Slots[i]); s != nil {
+				info.Slots = append(info.Slots, *s)
+			}
+		}
+	default:
+		return nil, ErrInvalidType
+	}
+
+	if _, err = readDigest(r, header); err != nil {
+		return nil, err
+	}
+
+	info.Version = vt[0]
+
+	return info, nil
+}
+
+// readLegacyInfo reads a version 1 header, which has no flags or digest.
+func readLegacyInfo(r io.Reader, t byte) (*Info, error) {
+	switch t {
+	case Password:
+		var h legacyPasswordHeader
+		if _, err := readHeader(r, &h); err != nil {
+			return nil, err
+		}
+		return &Info{
+			Version:    h.Version,
+			Type:       "password",
+			Variant:    argon2.Argon2d.String(),
+			Iterations: h.Iterations,
+			Memory:     h.Memory,
+			Lanes:      1,
+			Salt:       hex.EncodeToString(h.Salt[:]),
+		}, nil
+	case Curve448:
+		var h legacyCurve448Header
+		if _, err := readHeader(r, &h); err != nil {
+			return nil, err
+		}
+		return &Info{
+			Version:    h.Version,
+			Type:       "curve448",
+			Ephemeral:  hex.EncodeToString(h.Ephemeral[:]),
+			Recipients: 1,
+		}, nil
+	case Shard:
+		var h legacyShardHeader
+		if _, err := readHeader(r, &h); err != nil {
+			return nil, err
+		}
+		zero(h.Share[:])
+		return &Info{
+			Version: h.Version,
+			Type:    "shard",
+			Shard:   h.ID,
+		}, nil
+	}
+	return nil, ErrInvalidType
+}
+
+func slotInfo(i int, s *KeySlot) *SlotInfo {
+	switch s.Type {
+	case Password:
+		return &SlotInfo{
+			Slot:       i + 1,
+			Type:       "password",
+			Variant:    argon2.Variant(s.Variant).String(),
+			Iterations: s.Iterations,
+			Memory:     s.Memory,
+			Lanes:      s.Lanes,
+			Salt:       hex.EncodeToString(s.Salt[:]),
+		}
+	case Curve448:
+		return &SlotInfo{
+			Slot:      i + 1,
+			Type:      "curve448",
+			Ephemeral: hex.EncodeToString(s.Ephemeral[:]),
+		}
+	}
+	return nil
+}
+
+// String returns the Info as one field per line.
+func (i *Info) String() string {
+	b := &bytes.Buffer{}
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(b, "%-11s %v\n", name+":", value)
+	}
+
+	field("file", i.File)
+	if i.Size > 0 {
+		field("size", ByteSize(i.Size))
+	}
+	switch i.Version {
+	case Version, LegacyVersion:
+		field("version", i.Version)
+	default:
+		field("version", fmt.Sprintf("%d, unsupported", i.Version))
+		return b.String()
+	}
+	field("type", i.Type)
+
+	var flags []string
+	if i.Signed {
+		flags = append(flags, "signed")
+	}
+	if i.Dispersed {
+		flags = append(flags, "dispersed")
+	}
+	if len(flags) > 0 {
+		field("flags", strings.Join(flags, ", "))
+	}
+
+	switch i.Type {
+	case "password":
+		field("kdf", i.Variant)
+		field("iterations", i.Iterations)
+		field("memory", ByteSize(i.Memory)*KB)
+		field("lanes", i.Lanes)
+		field("salt", i.Salt)
+	case "curve448":
+		field("ephemeral", i.Ephemeral)
+		field("recipients", i.Recipients)
+	case "shard":
+		field("set", i.Set)
+		if i.Shards == 0 {
+			field("shard", i.Shard)
+			break
+		}
+		field("shard", fmt.Sprintf("%d of %d, %d required", i.Shard, i.Shards, i.Threshold))
+	}
+
+	for _, s := range i.Slots {
+		switch s.Type {
+		case "password":
+			memory := ByteSize(s.Memory) * KB
+			field(fmt.Sprintf("slot %d", s.Slot), fmt.Sprintf("password, %s, %d iterations, %s memory, %d lanes, salt %s",
+				s.Variant, s.Iterations, memory, s.Lanes, s.Salt))
+		case "curve448":
+			field(fmt.Sprintf("slot %d", s.Slot), fmt.Sprintf("curve448, ephemeral %s", s.Ephemeral))
+		}
+	}
+
+	return b.String()
+}
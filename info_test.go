@@ -0,0 +1,152 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPasswordArchiveInfo(t *testing.T) {
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 2, 16, 1, buf)
+	createArchive(t, arc)
+
+	info := readInfo(t, buf)
+	switch {
+	case info.Version != Version || info.Type != "password":
+		t.Fatal("password archive info has wrong version or type")
+	case info.Variant != "argon2id" || info.Lanes != 1:
+		t.Fatal("password archive info has wrong variant or lanes")
+	case info.Iterations != 2 || info.Memory != 16:
+		t.Fatal("password archive info has wrong cost parameters")
+	case info.Salt != hex.EncodeToString(arc.Salt[:]):
+		t.Fatal("password archive info has wrong salt")
+	}
+}
+
+func TestCurve448ArchiveInfo(t *testing.T) {
+	public0, _ := keypair(t)
+	public1, _ := keypair(t)
+	buf := &Buffer{}
+	arc := NewCurve448Archive([]*PublicKey{public0, public1}, nil, buf)
+	createArchive(t, arc)
+
+	info := readInfo(t, buf)
+	switch {
+	case info.Type != "curve448":
+		t.Fatal("curve448 archive info has wrong type")
+	case info.Ephemeral != hex.EncodeToString(arc.Ephemeral[:]):
+		t.Fatal("curve448 archive info has wrong ephemeral key")
+	case info.Recipients != 2:
+		t.Fatal("curve448 archive info has wrong recipient count")
+	}
+}
+
+func TestShardArchiveInfo(t *testing.T) {
+	arc := NewDispersedShardArchive(2, buffers(3))
+	createArchive(t, arc)
+
+	for _, shard := range arc.Shards {
+		info := readInfo(t, shard.File.(*Buffer))
+		switch {
+		case info.Type != "shard" || !info.Dispersed:
+			t.Fatal("shard archive info has wrong type or flags")
+		case info.Set != hex.EncodeToString(arc.Set[:]):
+			t.Fatal("shard archive info has wrong set")
+		case info.Shard != shard.ID || info.Threshold != 2 || info.Shards != 3:
+			t.Fatal("shard archive info has wrong shard numbers")
+		}
+	}
+}
+
+func TestKeySlotArchiveInfo(t *testing.T) {
+	public, _ := keypair(t)
+	buf := &Buffer{}
+	arc := NewKeySlotArchive(slotKeys(secret("secret"), public), nil, nil, buf)
+	createArchive(t, arc)
+
+	info := readInfo(t, buf)
+	switch {
+	case info.Type != "keyslots" || len(info.Slots) != 2:
+		t.Fatal("key slot archive info has wrong type or slots")
+	case info.Slots[0].Type != "password" || info.Slots[1].Type != "curve448":
+		t.Fatal("key slot archive info has wrong slot types")
+	}
+}
+
+func TestSignedArchiveInfo(t *testing.T) {
+	_, key := signingKeypair(t)
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+	arc.SetSigning(key, nil)
+	createArchive(t, arc)
+
+	if !readInfo(t, buf).Signed {
+		t.Fatal("signed archive info not signed")
+	}
+}
+
+func TestCorruptHeaderInfo(t *testing.T) {
+	buf := &Buffer{}
+	createArchive(t, NewPasswordArchive(secret("secret"), 1, 8, 1, buf))
+
+	buf.buffer[11] ^= 0xff
+
+	if _, err := ReadInfo(buf); err != ErrCorruptHeader {
+		t.Fatal("expected corrupt header got", err)
+	}
+
+	buf.Rewind()
+	buf.buffer[1] = 0xff
+
+	if _, err := ReadInfo(buf); err != ErrInvalidType {
+		t.Fatal("expected invalid type got", err)
+	}
+}
+
+func TestLegacyArchiveInfo(t *testing.T) {
+	info, err := ReadInfo(testdata(t, "password.arc"))
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case info.Version != LegacyVersion || info.Type != "password":
+		t.Fatal("legacy archive info has wrong version or type")
+	case info.Variant != "argon2d" || info.Lanes != 1:
+		t.Fatal("legacy archive info has wrong variant or lanes")
+	case info.Iterations != 1 || info.Memory != 16:
+		t.Fatal("legacy archive info has wrong cost parameters")
+	}
+
+	info, err = ReadInfo(testdata(t, "shard2.arc"))
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case info.Version != LegacyVersion || info.Type != "shard" || info.Shard == 0:
+		t.Fatal("legacy shard info has wrong version, type, or number")
+	}
+}
+
+func TestUnsupportedVersionInfo(t *testing.T) {
+	for _, version := range []byte{0x00, 0x05, Version + 1} {
+		info, err := ReadInfo(&Buffer{buffer: []byte{version, Password}})
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case info.Version != version || info.Type != "":
+			t.Fatalf("expected version %d only got %+v", version, info)
+		case !strings.Contains(info.String(), "unsupported"):
+			t.Fatalf("version %d info not shown as unsupported", version)
+		}
+	}
+}
+
+func readInfo(t *testing.T, buf *Buffer) *Info {
+	buf.Rewind()
+	info, err := ReadInfo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
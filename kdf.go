@@ -0,0 +1,57 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/wg/arc/argon2"
+)
+
+// Argon2id is the Argon2 variant used for new archives, key slots, and
+// key containers. Older variants are still read from their headers.
+const Argon2id = byte(argon2.Argon2id)
+
+// CostLimits are the highest Argon2 iterations and memory use, in KiB,
+// accepted from an archive, key slot, or key container. The cost is
+// read from the file before it can be authenticated, so a hostile file
+// could otherwise demand enough memory or time to exhaust the host.
+type CostLimits struct {
+	Iterations uint32
+	Memory     uint32
+}
+
+var Limits = CostLimits{
+	Iterations: 64,
+	Memory:     2 << 20,
+}
+
+// A CostError reports Argon2 cost parameters exceeding the CostLimits.
+type CostError struct {
+	Iterations uint32
+	Memory     uint32
+}
+
+func (e *CostError) Error() string {
+	memory := ByteSize(e.Memory) * KB
+	return fmt.Sprintf("archive: argon2 cost of %d iterations and %s memory exceeds --max-iterations or --max-memory",
+		e.Iterations, memory)
+}
+
+// Check returns a CostError if either parameter exceeds its limit.
+func (l CostLimits) Check(iterations, memory uint32) error {
+	if iterations > l.Iterations || memory > l.Memory {
+		return &CostError{iterations, memory}
+	}
+	return nil
+}
+
+// deriveKey derives a key of size bytes from a password and salt with
+// the Argon2 variant using memory KiB split into lanes.
+func deriveKey(password *Secret, salt []byte, variant, lanes byte, iterations, memory uint32, size int) (*Secret, error) {
+	key, err := argon2.Key(password.Bytes(), salt, argon2.Variant(variant), iterations, memory, lanes, size)
+	if err != nil {
+		return nil, err
+	}
+	return CopySecret(key), nil
+}
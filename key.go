@@ -3,23 +3,24 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/subtle"
 	"errors"
 	"io"
 
 	"github.com/dchest/blake2b"
-	"github.com/magical/argon2"
 	"github.com/wg/arc/binary"
 	"github.com/wg/ecies"
 	"github.com/wg/ecies/xchacha20poly1305"
 )
 
 const (
-	Public  = 0x01
-	Private = 0x02
-	NonSize = xchacha20poly1305.NonceSize
-	TagSize = xchacha20poly1305.TagSize
+	KeyVersion = 0x02
+	Public     = 0x01
+	Private    = 0x02
+	NonSize    = xchacha20poly1305.NonceSize
+	TagSize    = xchacha20poly1305.TagSize
 )
 
 type (
@@ -30,19 +31,22 @@ type (
 type KeyContainer struct {
 	Version    byte
 	Type       byte
+	Variant    byte
+	Lanes      byte
 	Iterations uint32
 	Memory     uint32
 	Salt       [32]byte
 	Tag        [TagSize]byte
 	Nonce      [NonSize]byte
 	Key        [56]byte
-	Password   []byte
+	Password   *Secret
 	File       io.ReadWriteCloser
 }
 
 var (
 	ErrInvalidPublicKey  = errors.New("invalid public key")
 	ErrInvalidPrivateKey = errors.New("invalid private key")
+	ErrInvalidKeyVersion = errors.New("unsupported key version")
 )
 
 func GenerateKeypair() (*PublicKey, *PrivateKey, error) {
@@ -51,7 +55,7 @@ func GenerateKeypair() (*PublicKey, *PrivateKey, error) {
 	return (*PublicKey)(&public), (*PrivateKey)(&private), err
 }
 
-func ComputeSharedKey(public *PublicKey, private *PrivateKey, size uint8) ([]byte, error) {
+func ComputeSharedKey(public *PublicKey, private *PrivateKey, size uint8) (*Secret, error) {
 	hash, err := blake2b.New(&blake2b.Config{Size: size})
 	if err != nil {
 		return nil, err
@@ -60,23 +64,74 @@ func ComputeSharedKey(public *PublicKey, private *PrivateKey, size uint8) ([]byt
 	var secret [56]byte
 	err = ecies.X448(&secret, (*[56]byte)(public), (*[56]byte)(private))
 	hash.Write(secret[:])
+	zero(secret[:])
 
-	for i := range secret {
-		secret[i] = 0
+	key := CopySecret(hash.Sum(nil))
+	hash.Reset()
+
+	if err != nil {
+		key.Close()
+		return nil, err
 	}
 
-	return hash.Sum(nil), err
+	return key, nil
+}
+
+// SealKey encrypts an archive key with a random nonce and a key
+// encryption key.
+func SealKey(kek, key *Secret, tag *[TagSize]byte, nonce *[NonSize]byte, sealed *[KeySize]byte) error {
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	x := &xchacha20poly1305.XChaCha20Poly1305{}
+	if err := x.Init(kek.Bytes(), nonce[:]); err != nil {
+		return err
+	}
+
+	x.Encrypt(sealed[:], key.Bytes())
+	x.Tag(tag[:0])
+
+	return nil
+}
+
+// OpenKey decrypts an archive key sealed by SealKey and reports whether
+// it was authenticated.
+func OpenKey(kek *Secret, tag *[TagSize]byte, nonce *[NonSize]byte, sealed *[KeySize]byte) (*Secret, bool) {
+	var computed [TagSize]byte
+
+	x := &xchacha20poly1305.XChaCha20Poly1305{}
+	if err := x.Init(kek.Bytes(), nonce[:]); err != nil {
+		return nil, false
+	}
+
+	key := NewSecret(KeySize)
+	x.Decrypt(key.Bytes(), sealed[:])
+	x.Tag(computed[:0])
+
+	if subtle.ConstantTimeCompare(tag[:], computed[:]) != 1 {
+		key.Close()
+		return nil, false
+	}
+
+	return key, true
 }
 
 func (private *PrivateKey) Zero() {
-	for i := range private {
-		private[i] = 0
+	zero(private[:])
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
 	}
 }
 
-func NewKeyContainer(file io.ReadWriteCloser, password []byte, iterations, memory uint32) *KeyContainer {
+func NewKeyContainer(file io.ReadWriteCloser, password *Secret, iterations, memory uint32, lanes byte) *KeyContainer {
 	return &KeyContainer{
-		Version:    1,
+		Version:    KeyVersion,
+		Variant:    Argon2id,
+		Lanes:      lanes,
 		Iterations: iterations,
 		Memory:     memory,
 		Password:   password,
@@ -100,20 +155,74 @@ func (c *KeyContainer) WritePrivateKey(key *PrivateKey) error {
 	return c.write(Private, (*[56]byte)(key))
 }
 
+// ReadSigningPublicKey reads an Ed25519 public key, which is stored
+// zero padded to the size of a Curve448 key.
+func (c *KeyContainer) ReadSigningPublicKey(key *SigningPublicKey) error {
+	var padded [56]byte
+	err := c.read(SigningPublic, &padded)
+	copy(key[:], padded[:])
+	return err
+}
+
+func (c *KeyContainer) WriteSigningPublicKey(key *SigningPublicKey) error {
+	var padded [56]byte
+	copy(padded[:], key[:])
+	return c.write(SigningPublic, &padded)
+}
+
+// ReadSigningPrivateKey reads an Ed25519 private key seed, which is
+// stored zero padded to the size of a Curve448 key.
+func (c *KeyContainer) ReadSigningPrivateKey(key *SigningPrivateKey) error {
+	var padded [56]byte
+	defer zero(padded[:])
+	err := c.read(SigningPrivate, &padded)
+	copy(key[:], padded[:])
+	return err
+}
+
+func (c *KeyContainer) WriteSigningPrivateKey(key *SigningPrivateKey) error {
+	var padded [56]byte
+	defer zero(padded[:])
+	copy(padded[:], key[:])
+	return c.write(SigningPrivate, &padded)
+}
+
+// Close closes the file and zeroes the password.
 func (c *KeyContainer) Close() error {
+	c.Password.Close()
 	return c.File.Close()
 }
 
 func (c *KeyContainer) read(t byte, key *[56]byte) error {
-	switch err := binary.Read(c.File, binary.LE, c); {
+	var version [1]byte
+	if _, err := io.ReadFull(c.File, version[:]); err != nil {
+		return err
+	}
+	r := io.MultiReader(bytes.NewReader(version[:]), c.File)
+
+	var err error
+	switch version[0] {
+	case KeyVersion:
+		err = binary.Read(r, binary.LE, c)
+	case LegacyVersion:
+		err = c.readLegacy(r)
+	default:
+		return ErrInvalidKeyVersion
+	}
+
+	switch {
 	case err != nil:
 		return err
-	case c.Type != t && t == Public:
+	case c.Type != t && (t == Public || t == SigningPublic):
 		return ErrInvalidPublicKey
-	case c.Type != t && t == Private:
+	case c.Type != t:
 		return ErrInvalidPrivateKey
 	}
 
+	if err := Limits.Check(c.Iterations, c.Memory); err != nil {
+		return err
+	}
+
 	var tag [TagSize]byte
 	x, err := c.cipher()
 	if err != nil {
@@ -153,18 +262,13 @@ func (c *KeyContainer) write(t byte, key *[56]byte) error {
 }
 
 func (c *KeyContainer) cipher() (*xchacha20poly1305.XChaCha20Poly1305, error) {
-	var (
-		salt       = c.Salt[:]
-		iterations = int(c.Iterations)
-		memory     = int64(c.Memory)
-		keySize    = xchacha20poly1305.KeySize
-	)
-
-	key, err := argon2.Key(c.Password, salt, iterations, 1, memory, keySize)
+	keySize := xchacha20poly1305.KeySize
+	key, err := deriveKey(c.Password, c.Salt[:], c.Variant, c.Lanes, c.Iterations, c.Memory, keySize)
 	if err != nil {
 		return nil, err
 	}
+	defer key.Close()
 
 	x := &xchacha20poly1305.XChaCha20Poly1305{}
-	return x, x.Init(key[:], c.Nonce[:])
+	return x, x.Init(key.Bytes(), c.Nonce[:])
 }
@@ -6,7 +6,7 @@ import (
 	"bytes"
 	"testing"
 
-	"github.com/magical/argon2"
+	"github.com/wg/arc/argon2"
 	"github.com/wg/arc/binary"
 	"github.com/wg/ecies/xchacha20poly1305"
 )
@@ -34,7 +34,7 @@ func TestPrivateKeyFormat(t *testing.T) {
 }
 
 func CheckKeyFormat(t *testing.T, k *[56]byte, b *Buffer, c *KeyContainer) {
-	key, err := argon2.Key(c.Password, c.Salt[:], int(c.Iterations), 1, int64(c.Memory), KeySize)
+	key, err := argon2.Key(c.Password.Bytes(), c.Salt[:], argon2.Argon2id, c.Iterations, c.Memory, c.Lanes, KeySize)
 	if err != nil {
 		t.Fatal("password key derivation failed", err)
 	}
@@ -58,23 +58,27 @@ func CheckKeyFormat(t *testing.T, k *[56]byte, b *Buffer, c *KeyContainer) {
 		t.Fatal("authentication tag incorrect")
 	}
 
-	if binary.LE.Uint32(b.buffer[2:6]) != c.Iterations {
+	if b.buffer[2] != Argon2id || b.buffer[3] != c.Lanes {
+		t.Fatal("serialized variant or lanes incorrect")
+	}
+
+	if binary.LE.Uint32(b.buffer[4:8]) != c.Iterations {
 		t.Fatal("serialized iterations incorrect")
 	}
 
-	if binary.LE.Uint32(b.buffer[6:10]) != c.Memory {
+	if binary.LE.Uint32(b.buffer[8:12]) != c.Memory {
 		t.Fatal("serialized memory incorrect")
 	}
 
-	if !bytes.Equal(b.buffer[10:42], c.Salt[:]) {
+	if !bytes.Equal(b.buffer[12:44], c.Salt[:]) {
 		t.Fatal("serialized salt incorrect")
 	}
 
-	if !bytes.Equal(b.buffer[42:58], tag[:]) {
+	if !bytes.Equal(b.buffer[44:60], tag[:]) {
 		t.Fatal("serialized tag incorrect")
 	}
 
-	if !bytes.Equal(b.buffer[58:82], c.Nonce[:]) {
+	if !bytes.Equal(b.buffer[60:84], c.Nonce[:]) {
 		t.Fatal("serialized nonce incorrect")
 	}
 }
@@ -105,11 +109,23 @@ func TestPublicPrivateKeypair(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !bytes.Equal(shared0, shared1) {
+	if !shared0.Equal(shared1) {
 		t.Fatal("serialized keys incorrect")
 	}
 }
 
+func TestKeyCostLimits(t *testing.T) {
+	defer func(limits CostLimits) { Limits = limits }(Limits)
+
+	_, priv := keypair(t)
+	_, c := StorePrivateKey(t, priv)
+
+	Limits = CostLimits{1, 4}
+	if err := c.ReadPrivateKey(priv); !costError(err, 1, 8) {
+		t.Fatal("expected cost error got", err)
+	}
+}
+
 func TestWrongKeyType(t *testing.T) {
 	pub, priv := keypair(t)
 
@@ -127,7 +143,7 @@ func TestWrongKeyType(t *testing.T) {
 
 func StorePublicKey(t *testing.T, key *PublicKey) (*Buffer, *KeyContainer) {
 	b := &Buffer{}
-	c := NewKeyContainer(b, []byte(""), 1, 8)
+	c := NewKeyContainer(b, nil, 1, 8, 1)
 
 	if err := c.WritePublicKey(key); err != nil {
 		t.Fatal("failed to store public key", err)
@@ -140,7 +156,7 @@ func StorePublicKey(t *testing.T, key *PublicKey) (*Buffer, *KeyContainer) {
 
 func StorePrivateKey(t *testing.T, key *PrivateKey) (*Buffer, *KeyContainer) {
 	b := &Buffer{}
-	c := NewKeyContainer(b, []byte("secret"), 1, 8)
+	c := NewKeyContainer(b, secret("secret"), 1, 8, 1)
 
 	if err := c.WritePrivateKey(key); err != nil {
 		t.Fatal("failed to store private key", err)
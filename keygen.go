@@ -7,6 +7,7 @@ func (c *Cmd) Keygen(puc *KeyContainer, prc *KeyContainer) error {
 	if err != nil {
 		return err
 	}
+	defer private.Zero()
 
 	if err = puc.WritePublicKey(public); err != nil {
 		return err
@@ -18,3 +19,21 @@ func (c *Cmd) Keygen(puc *KeyContainer, prc *KeyContainer) error {
 
 	return nil
 }
+
+func (c *Cmd) SigningKeygen(puc *KeyContainer, prc *KeyContainer) error {
+	public, private, err := GenerateSigningKeypair()
+	if err != nil {
+		return err
+	}
+	defer private.Zero()
+
+	if err = puc.WriteSigningPublicKey(public); err != nil {
+		return err
+	}
+
+	if err = prc.WriteSigningPrivateKey(private); err != nil {
+		return err
+	}
+
+	return nil
+}
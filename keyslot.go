@@ -0,0 +1,383 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dchest/blake2b"
+)
+
+const (
+	KeySlots = 0x04
+	MaxSlots = 8
+	Empty    = 0x00
+)
+
+var (
+	ErrKeySlotArchive = errors.New("archive: key slot archive")
+	ErrNoKeySlot      = errors.New("archive: no key slot opened")
+	ErrNoFreeKeySlot  = errors.New("archive: no free key slot")
+	ErrInvalidKeySlot = errors.New("archive: invalid key slot")
+	ErrLastKeySlot    = errors.New("archive: can't remove last key slot")
+	ErrNotFile        = errors.New("archive: key slots can only be rewritten in a file")
+)
+
+// A KeySlotArchive is encrypted with a key consisting of cryptographically
+// secure random bytes. That key is encrypted in up to MaxSlots key slots,
+// each with a key derived from its own password and Argon2 parameters or
+// from a Curve448 key exchange. Any one slot opens the archive and slots
+// can be added or removed by writing a new fixed size header followed by
+// a copy of the encrypted data, which is not re-encrypted.
+type KeySlotArchive struct {
+	Version    byte
+	Type       byte
+	Flags      byte
+	Slots      [MaxSlots]KeySlot
+	Keys       []SlotKey
+	Password   *Secret
+	PrivateKey *PrivateKey
+	File       File
+	Signing
+}
+
+// A KeySlot holds the archive key encrypted with a key derived from a
+// password when Type is Password or from an X448 key exchange with an
+// ephemeral private key and a static public key when Type is Curve448.
+type KeySlot struct {
+	Type       byte
+	Variant    byte
+	Lanes      byte
+	Iterations uint32
+	Memory     uint32
+	Salt       [32]byte
+	Ephemeral  PublicKey
+	Tag        [TagSize]byte
+	Nonce      [NonSize]byte
+	Key        [KeySize]byte
+}
+
+// A SlotKey is the password or public key used to fill a key slot.
+type SlotKey struct {
+	Password   *Secret
+	Iterations uint32
+	Memory     uint32
+	Lanes      byte
+	PublicKey  *PublicKey
+}
+
+func NewKeySlotArchive(keys []SlotKey, password *Secret, private *PrivateKey, file File) *KeySlotArchive {
+	return &KeySlotArchive{
+		Version:    Version,
+		Type:       KeySlots,
+		Keys:       keys,
+		Password:   password,
+		PrivateKey: private,
+		File:       file,
+	}
+}
+
+func (a *KeySlotArchive) Reader() (*Reader, error) {
+	key, err := a.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveReader(key, a.Digest(), &a.Signing, a.File, a.File)
+}
+
+func (a *KeySlotArchive) Writer() (*Writer, error) {
+	key := NewSecret(KeySize)
+
+	_, err := rand.Read(key.Bytes())
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	a.Flags = a.flags()
+
+	for _, k := range a.Keys {
+		if _, err = a.Add(key, k); err != nil {
+			key.Close()
+			return nil, err
+		}
+	}
+
+	_, err = writeHeader(a.File, a.headers()...)
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	return newArchiveWriter(key, a.Digest(), &a.Signing, a.File, a.File)
+}
+
+// ReadHeader reads the archive header and key slots.
+func (a *KeySlotArchive) ReadHeader() error {
+	header, err := readHeader(a.File, a)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case a.Version == LegacyVersion:
+		return typeError(a.Type)
+	case a.Version != Version:
+		return versionError(a.Version)
+	case a.Type == Password:
+		return ErrPasswordArchive
+	case a.Type == Curve448:
+		return ErrCurve448Archive
+	case a.Type == Shard:
+		return ErrShardArchive
+	}
+
+	if err = a.check(a.Flags); err != nil {
+		return err
+	}
+
+	for i := range a.Slots {
+		b, err := readHeader(a.File, &a.Slots[i])
+		if err != nil {
+			return err
+		}
+		header = append(header, b...)
+	}
+
+	_, err = readDigest(a.File, header)
+	return err
+}
+
+// Unlock reads the header and returns the archive key from the first
+// key slot that opens with the password or private key.
+func (a *KeySlotArchive) Unlock() (*Secret, error) {
+	err := a.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range a.Slots {
+		key, err := a.Slots[i].Open(a.Password, a.PrivateKey)
+		switch {
+		case err != nil:
+			return nil, err
+		case key != nil:
+			return key, nil
+		}
+	}
+
+	return nil, ErrNoKeySlot
+}
+
+// Add encrypts the archive key in the first empty key slot and returns
+// the slot's index.
+func (a *KeySlotArchive) Add(key *Secret, k SlotKey) (int, error) {
+	for i := range a.Slots {
+		if a.Slots[i].Type == Empty {
+			return i, a.Slots[i].Seal(key, k)
+		}
+	}
+	return 0, ErrNoFreeKeySlot
+}
+
+// Remove clears the key slot at index i.
+func (a *KeySlotArchive) Remove(i int) error {
+	used := 0
+	for _, s := range a.Slots {
+		if s.Type != Empty {
+			used++
+		}
+	}
+
+	switch {
+	case i < 0 || i >= MaxSlots || a.Slots[i].Type == Empty:
+		return ErrInvalidKeySlot
+	case used == 1:
+		return ErrLastKeySlot
+	}
+
+	a.Slots[i] = KeySlot{}
+
+	return nil
+}
+
+// Rewrite replaces the header of the archive file with the current key
+// slots, leaving the encrypted data untouched. The new header and a copy
+// of the data are written to a temporary file in the same directory and
+// synced before it is renamed over the archive, so a failure at any
+// point leaves either the old or the new archive intact.
+func (a *KeySlotArchive) Rewrite() error {
+	f, ok := a.File.(*os.File)
+	if !ok {
+		return ErrNotFile
+	}
+
+	path, err := filepath.EvalSymlinks(f.Name())
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	b := &bytes.Buffer{}
+	if _, err = writeHeader(b, a.headers()...); err != nil {
+		return err
+	}
+
+	tmp, err := temp(path)
+	if err != nil {
+		return err
+	}
+
+	data := io.NewSectionReader(f, int64(b.Len()), info.Size()-int64(b.Len()))
+	if err = writeFile(tmp, info, b.Bytes(), data); err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// writeFile creates a file at path with the mode and, where possible, the
+// owner of info, writes the header and data to it, and syncs it to disk.
+func writeFile(path string, info os.FileInfo, header []byte, data io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if uid, gid, ok := fileOwner(info); ok {
+		f.Chown(uid, gid)
+	}
+
+	if _, err = f.Write(header); err == nil {
+		if _, err = io.Copy(f, data); err == nil {
+			err = f.Sync()
+		}
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// syncDir syncs a directory so a rename within it is durable. Not every
+// platform can sync a directory so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Digest returns the digest of the header fields that can't change when
+// key slots are added or removed.
+func (a *KeySlotArchive) Digest() []byte {
+	sum := blake2b.Sum256([]byte{a.Version, a.Type, a.Flags})
+	return sum[:]
+}
+
+func (a *KeySlotArchive) Close() error {
+	return a.File.Close()
+}
+
+func (a *KeySlotArchive) headers() []interface{} {
+	headers := []interface{}{a}
+	for i := range a.Slots {
+		headers = append(headers, &a.Slots[i])
+	}
+	return headers
+}
+
+// Seal encrypts the archive key with a key derived from the password
+// or public key.
+func (s *KeySlot) Seal(key *Secret, k SlotKey) error {
+	var kek *Secret
+	var err error
+
+	switch {
+	case k.PublicKey != nil:
+		kek, err = s.sealCurve448(k.PublicKey)
+	default:
+		kek, err = s.sealPassword(k.Password, k.Iterations, k.Memory, k.Lanes)
+	}
+
+	if err != nil {
+		return err
+	}
+	defer kek.Close()
+
+	return SealKey(kek, key, &s.Tag, &s.Nonce, &s.Key)
+}
+
+// Open attempts to decrypt the archive key with the password or private
+// key, returning a nil key if this slot is empty or does not match.
+func (s *KeySlot) Open(password *Secret, private *PrivateKey) (*Secret, error) {
+	var kek *Secret
+	var err error
+
+	switch {
+	case s.Type == Password && password != nil:
+		if err = Limits.Check(s.Iterations, s.Memory); err == nil {
+			kek, err = s.passwordKey(password)
+		}
+	case s.Type == Curve448 && private != nil:
+		kek, err = ComputeSharedKey(&s.Ephemeral, private, KeySize)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer kek.Close()
+
+	key, _ := OpenKey(kek, &s.Tag, &s.Nonce, &s.Key)
+	return key, nil
+}
+
+func (s *KeySlot) sealPassword(password *Secret, iterations, memory uint32, lanes byte) (*Secret, error) {
+	if _, err := rand.Read(s.Salt[:]); err != nil {
+		return nil, err
+	}
+
+	s.Type = Password
+	s.Variant = Argon2id
+	s.Lanes = lanes
+	s.Iterations = iterations
+	s.Memory = memory
+
+	return s.passwordKey(password)
+}
+
+func (s *KeySlot) sealCurve448(public *PublicKey) (*Secret, error) {
+	ephemeralPublicKey, ephemeralPrivateKey, err := GenerateKeypair()
+	if err != nil {
+		return nil, err
+	}
+	defer ephemeralPrivateKey.Zero()
+
+	s.Type = Curve448
+	s.Ephemeral = *ephemeralPublicKey
+
+	return ComputeSharedKey(public, ephemeralPrivateKey, KeySize)
+}
+
+func (s *KeySlot) passwordKey(password *Secret) (*Secret, error) {
+	return deriveKey(password, s.Salt[:], s.Variant, s.Lanes, s.Iterations, s.Memory, KeySize)
+}
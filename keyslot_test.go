@@ -0,0 +1,168 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeySlotArchive(t *testing.T) {
+	public, private := keypair(t)
+	password := secret("secret")
+
+	buf := &Buffer{}
+	arc := NewKeySlotArchive(slotKeys(password, public), nil, nil, buf)
+	dat := createArchive(t, arc)
+
+	verifyArchive(t, NewKeySlotArchive(nil, password, nil, buf), dat)
+	buf.Rewind()
+	verifyArchive(t, NewKeySlotArchive(nil, nil, private, buf), dat)
+}
+
+func TestKeySlotArchiveFormat(t *testing.T) {
+	public, _ := keypair(t)
+	buf := &Buffer{}
+	arc := NewKeySlotArchive(slotKeys(secret("secret"), public), nil, nil, buf)
+	createArchive(t, arc)
+
+	const size = 1 + 1 + 1 + 4 + 4 + 32 + 56 + TagSize + NonSize + KeySize
+
+	switch {
+	case buf.buffer[0] != Version:
+		t.Fatal("wrong version in key slot archive")
+	case buf.buffer[1] != KeySlots:
+		t.Fatal("wrong type in key slot archive")
+	case buf.buffer[3] != Password:
+		t.Fatal("serialized password slot type incorrect")
+	case buf.buffer[3+size] != Curve448:
+		t.Fatal("serialized curve448 slot type incorrect")
+	case buf.buffer[4] != Argon2id || buf.buffer[5] != 1:
+		t.Fatal("serialized variant or lanes incorrect")
+	case !bytes.Equal(buf.buffer[3+11:3+43], arc.Slots[0].Salt[:]):
+		t.Fatal("serialized salt incorrect")
+	case !bytes.Equal(buf.buffer[3+size+43:3+size+99], arc.Slots[1].Ephemeral[:]):
+		t.Fatal("serialized ephemeral public key incorrect")
+	}
+
+	for i := 2; i < MaxSlots; i++ {
+		if !bytes.Equal(buf.buffer[3+i*size:3+(i+1)*size], make([]byte, size)) {
+			t.Fatal("serialized empty slot incorrect")
+		}
+	}
+}
+
+func TestWrongKeySlotPassword(t *testing.T) {
+	buf := &Buffer{}
+	arc := NewKeySlotArchive(slotKeys(secret("secret"), nil), nil, nil, buf)
+	createArchive(t, arc)
+
+	if _, err := NewKeySlotArchive(nil, secret("terces"), nil, buf).Reader(); err != ErrNoKeySlot {
+		t.Fatal("expected no key slot got", err)
+	}
+}
+
+func TestAddRemoveKeySlot(t *testing.T) {
+	public, private := keypair(t)
+	password := secret("secret")
+
+	buf := &Buffer{}
+	arc := NewKeySlotArchive(slotKeys(password, nil), nil, nil, buf)
+	dat := createArchive(t, arc)
+	size := len(buf.buffer)
+
+	dir, err := ioutil.TempDir("", "arc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "slots.arc")
+	if err = ioutil.WriteFile(path, buf.buffer, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	arc = NewKeySlotArchive(nil, password, nil, file)
+	key, err := arc.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := arc.Add(key, SlotKey{PublicKey: public}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arc.Remove(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arc.Remove(1); err != ErrLastKeySlot {
+		t.Fatal("expected last key slot got", err)
+	}
+
+	if err := arc.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, _ := ioutil.ReadDir(dir); len(names) != 1 {
+		t.Fatalf("expected only the archive in %s got %d files", dir, len(names))
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case info.Size() != int64(size):
+		t.Fatal("rewritten header changed archive size")
+	case info.Mode().Perm() != 0640:
+		t.Fatalf("expected mode 0640 got %v", info.Mode())
+	}
+
+	if buf.buffer, err = ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Rewind()
+	if _, err := NewKeySlotArchive(nil, password, nil, buf).Reader(); err != ErrNoKeySlot {
+		t.Fatal("removed key slot opened archive", err)
+	}
+
+	buf.Rewind()
+	verifyArchive(t, NewKeySlotArchive(nil, nil, private, buf), dat)
+
+	if err := NewKeySlotArchive(nil, nil, nil, buf).Rewrite(); err != ErrNotFile {
+		t.Fatalf("expected %v got %v", ErrNotFile, err)
+	}
+}
+
+func TestKeySlotsFull(t *testing.T) {
+	keys := make([]SlotKey, MaxSlots+1)
+	for i := range keys {
+		keys[i] = slotKeys(secret("secret"), nil)[0]
+	}
+
+	arc := NewKeySlotArchive(keys, nil, nil, &Buffer{})
+	if _, err := arc.Writer(); err != ErrNoFreeKeySlot {
+		t.Fatal("expected no free key slot got", err)
+	}
+}
+
+func slotKeys(password *Secret, public *PublicKey) []SlotKey {
+	var keys []SlotKey
+	if password != nil {
+		keys = append(keys, SlotKey{Password: password, Iterations: 1, Memory: 8, Lanes: 1})
+	}
+	if public != nil {
+		keys = append(keys, SlotKey{PublicKey: public})
+	}
+	return keys
+}
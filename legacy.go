@@ -0,0 +1,251 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codahale/sss"
+	"github.com/wg/arc/archive"
+	"github.com/wg/arc/argon2"
+	"github.com/wg/arc/binary"
+)
+
+// LegacyVersion is the version of archives written by the first release
+// of arc, which are still read but never written.
+const LegacyVersion = 0x01
+
+var (
+	ErrLegacyStream = errors.New("archive: version 1 archive must be read from a file, not a pipe")
+)
+
+// A VersionError reports an archive written in a development version of
+// the format that was never released and is no longer read.
+type VersionError struct {
+	Version byte
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("archive: unsupported version %d, extract it with the arc build that created it and re-encrypt it", e.Version)
+}
+
+// versionError returns the error for an archive with a version that is
+// neither LegacyVersion nor Version.
+func versionError(version byte) error {
+	if version > LegacyVersion && version < Version {
+		return &VersionError{version}
+	}
+	return ErrInvalidVersion
+}
+
+// typeError returns the error for an archive of an unexpected type.
+func typeError(t byte) error {
+	switch t {
+	case Password:
+		return ErrPasswordArchive
+	case Curve448:
+		return ErrCurve448Archive
+	case Shard:
+		return ErrShardArchive
+	case KeySlots:
+		return ErrKeySlotArchive
+	}
+	return ErrInvalidType
+}
+
+type legacyPasswordHeader struct {
+	Version    byte
+	Type       byte
+	Iterations uint32
+	Memory     uint32
+	Salt       [32]byte
+}
+
+type legacyCurve448Header struct {
+	Version   byte
+	Type      byte
+	Ephemeral PublicKey
+}
+
+type legacyShardHeader struct {
+	Version byte
+	Type    byte
+	ID      byte
+	Share   [KeySize]byte
+}
+
+type legacyKeyContainer struct {
+	Version    byte
+	Type       byte
+	Iterations uint32
+	Memory     uint32
+	Salt       [32]byte
+	Tag        [TagSize]byte
+	Nonce      [NonSize]byte
+	Key        [56]byte
+}
+
+// legacyKey derives a key as version 1 did, with Argon2d computed in a
+// single lane over memory KiB rounded down to a multiple of 4.
+func legacyKey(password *Secret, salt []byte, iterations, memory uint32, size int) (*Secret, error) {
+	return deriveKey(password, salt, byte(argon2.Argon2d), 1, iterations, memory&^3, size)
+}
+
+// seekStart returns file positioned at its start, after the version and
+// type have been read, so a version 1 header can be read in full.
+func seekStart(file File) (io.ReadSeeker, error) {
+	f, ok := file.(io.ReadSeeker)
+	if !ok {
+		return nil, ErrLegacyStream
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, ErrLegacyStream
+	}
+	return f, nil
+}
+
+// newLegacyReader returns a Reader of a version 1 archive, which is
+// authenticated in full before any entry is read.
+func newLegacyReader(key *Secret, r io.ReadSeeker, closers ...io.Closer) (*Reader, error) {
+	ar, err := archive.NewLegacyReader(r, key.Bytes())
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	return &Reader{
+		key:     key,
+		closers: closers,
+		Reader:  ar,
+	}, nil
+}
+
+func (a *PasswordArchive) legacyReader() (*Reader, error) {
+	if a.Type != Password {
+		return nil, typeError(a.Type)
+	}
+
+	if err := a.check(0); err != nil {
+		return nil, err
+	}
+
+	f, err := seekStart(a.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var header legacyPasswordHeader
+	if err = binary.Read(f, binary.LE, &header); err != nil {
+		return nil, err
+	}
+
+	if err = Limits.Check(header.Iterations, header.Memory); err != nil {
+		return nil, err
+	}
+
+	key, err := legacyKey(a.Password, header.Salt[:], header.Iterations, header.Memory, KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLegacyReader(key, f, a.File)
+}
+
+func (a *Curve448Archive) legacyReader() (*Reader, error) {
+	if a.Type != Curve448 {
+		return nil, typeError(a.Type)
+	}
+
+	if err := a.check(0); err != nil {
+		return nil, err
+	}
+
+	f, err := seekStart(a.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var header legacyCurve448Header
+	if err = binary.Read(f, binary.LE, &header); err != nil {
+		return nil, err
+	}
+
+	key, err := ComputeSharedKey(&header.Ephemeral, a.PrivateKey, KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLegacyReader(key, f, a.File)
+}
+
+// legacyReader combines every shard given, as version 1 shards do not
+// record their threshold, and reads the data from the first.
+func (a *ShardArchive) legacyReader() (*Reader, error) {
+	if err := a.check(0); err != nil {
+		return nil, err
+	}
+
+	var first io.ReadSeeker
+	shares := make(map[byte][]byte, len(a.Shards))
+	defer func() {
+		for _, share := range shares {
+			zero(share)
+		}
+	}()
+
+	for _, shard := range a.Shards {
+		f, err := seekStart(shard.File)
+		if err != nil {
+			return nil, err
+		}
+
+		var header legacyShardHeader
+		if err = binary.Read(f, binary.LE, &header); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case header.Version != LegacyVersion:
+			return nil, ErrMixedShards
+		case header.Type != Shard:
+			return nil, typeError(header.Type)
+		}
+
+		share := make([]byte, KeySize)
+		copy(share, header.Share[:])
+		zero(header.Share[:])
+		shares[header.ID] = share
+
+		if first == nil {
+			first = f
+		}
+	}
+
+	key := CopySecret(sss.Combine(shares))
+
+	return newLegacyReader(key, first, a.closers()...)
+}
+
+// readLegacy reads a version 1 key container, which has no Argon2
+// variant or lanes, and sets the parameters its key was derived with.
+func (c *KeyContainer) readLegacy(r io.Reader) error {
+	var l legacyKeyContainer
+	if err := binary.Read(r, binary.LE, &l); err != nil {
+		return err
+	}
+
+	c.Version = l.Version
+	c.Type = l.Type
+	c.Variant = byte(argon2.Argon2d)
+	c.Lanes = 1
+	c.Iterations = l.Iterations
+	c.Memory = l.Memory &^ 3
+	c.Salt = l.Salt
+	c.Tag = l.Tag
+	c.Nonce = l.Nonce
+	c.Key = l.Key
+
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The archives and keys in testdata were created by the first release
+// of arc with the password secret, 1 iteration, and 16 KiB of memory,
+// and each archive holds hello.txt.
+
+func TestLegacyPasswordArchive(t *testing.T) {
+	arc := NewPasswordArchive(secret("secret"), 0, 0, 0, testdata(t, "password.arc"))
+	verifyLegacyArchive(t, arc)
+}
+
+func TestLegacyShardArchive(t *testing.T) {
+	for _, names := range [][]string{{"shard1.arc", "shard2.arc"}, {"shard3.arc", "shard1.arc"}} {
+		arc := NewShardArchive(0, []File{testdata(t, names[0]), testdata(t, names[1])})
+		verifyLegacyArchive(t, arc)
+	}
+}
+
+func TestLegacyWrongPassword(t *testing.T) {
+	arc := NewPasswordArchive(secret("wrong"), 0, 0, 0, testdata(t, "password.arc"))
+	ensureInvalid(t, arc)
+}
+
+func TestLegacyTamperedArchive(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "password.arc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0x01
+
+	arc := NewPasswordArchive(secret("secret"), 0, 0, 0, &Buffer{buffer: b})
+	ensureInvalid(t, arc)
+}
+
+func TestLegacyStream(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "password.arc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arc := NewPasswordArchive(secret("secret"), 0, 0, 0, &Pipe{Reader: bytes.NewReader(b)})
+	if _, err := arc.Reader(); err != ErrLegacyStream {
+		t.Fatalf("expected %v got %v", ErrLegacyStream, err)
+	}
+}
+
+func TestLegacyArchiveType(t *testing.T) {
+	arc := NewCurve448Archive(nil, nil, testdata(t, "password.arc"))
+	if _, err := arc.Reader(); err != ErrPasswordArchive {
+		t.Fatalf("expected %v got %v", ErrPasswordArchive, err)
+	}
+}
+
+func TestLegacyKeys(t *testing.T) {
+	public, private := legacyKeypair(t)
+
+	ephemeralPublic, ephemeralPrivate := keypair(t)
+	a, err := ComputeSharedKey(public, ephemeralPrivate, KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ComputeSharedKey(ephemeralPublic, private, KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.Equal(b) {
+		t.Fatal("legacy public and private keys are not a pair")
+	}
+
+	c := NewKeyContainer(testdata(t, "private.key"), secret("wrong"), 0, 0, 0)
+	if err := c.ReadPrivateKey(&PrivateKey{}); err != ErrInvalidPrivateKey {
+		t.Fatal("expected invalid private key got", err)
+	}
+}
+
+func TestLegacyCurve448Archive(t *testing.T) {
+	_, private := legacyKeypair(t)
+	arc := NewCurve448Archive(nil, private, testdata(t, "curve448.arc"))
+	verifyLegacyArchive(t, arc)
+}
+
+func TestObsoleteVersion(t *testing.T) {
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+	createArchive(t, arc)
+
+	b := arc.File.(*Buffer)
+	b.buffer[0] = Version - 1
+	b.Rewind()
+
+	_, err := arc.Reader()
+	if err, ok := err.(*VersionError); !ok || err.Version != Version-1 {
+		t.Fatalf("expected version error got %v", err)
+	}
+
+	b.buffer[0] = Version + 1
+	b.Rewind()
+
+	if _, err = arc.Reader(); err != ErrInvalidVersion {
+		t.Fatalf("expected %v got %v", ErrInvalidVersion, err)
+	}
+}
+
+func verifyLegacyArchive(t *testing.T, a Archiver) {
+	r, err := a.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	h, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch b, err := ioutil.ReadAll(r); {
+	case err != nil:
+		t.Fatal(err)
+	case h.Name != "hello.txt" || string(b) != "hello, world\n":
+		t.Fatalf("unexpected entry %s with content %q", h.Name, b)
+	}
+
+	if !r.Verify() {
+		t.Fatal("archive verify failed")
+	}
+}
+
+func testdata(t *testing.T, name string) File {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func legacyKeypair(t *testing.T) (*PublicKey, *PrivateKey) {
+	var public PublicKey
+	var private PrivateKey
+
+	c := NewKeyContainer(testdata(t, "public.key"), nil, 0, 0, 0)
+	if err := c.ReadPublicKey(&public); err != nil {
+		t.Fatal(err)
+	}
+
+	c = NewKeyContainer(testdata(t, "private.key"), secret("secret"), 0, 0, 0)
+	if err := c.ReadPrivateKey(&private); err != nil {
+		t.Fatal(err)
+	}
+
+	return &public, &private
+}
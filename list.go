@@ -52,12 +52,21 @@ func mode(h *tar.Header) string {
 		mode |= os.ModeDir
 	case tar.TypeSymlink:
 		mode |= os.ModeSymlink
+	case tar.TypeChar:
+		mode |= os.ModeCharDevice
+	case tar.TypeBlock:
+		mode |= os.ModeDevice
+	case tar.TypeFifo:
+		mode |= os.ModeNamedPipe
 	}
 	return mode.String()
 }
 
 func size(h *tar.Header) string {
-	if h.Size == 0 {
+	switch {
+	case h.Typeflag == tar.TypeChar || h.Typeflag == tar.TypeBlock:
+		return fmt.Sprintf("%d,%d", h.Devmajor, h.Devminor)
+	case h.Size == 0:
 		return "0"
 	}
 	return ByteSize(h.Size).String()
@@ -65,8 +74,11 @@ func size(h *tar.Header) string {
 
 func name(h *tar.Header) string {
 	name := h.Name
-	if h.Typeflag == tar.TypeSymlink {
+	switch h.Typeflag {
+	case tar.TypeSymlink:
 		name += " -> " + h.Linkname
+	case tar.TypeLink:
+		name += " link to " + h.Linkname
 	}
 	return name
 }
@@ -4,40 +4,75 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/wg/arc/archive"
 )
 
 type Cmd struct {
-	Op       interface{}
-	Archiver Archiver
-	Verbose  int
-	Names    []string
-	Private  *KeyContainer
-	Public   *KeyContainer
+	Op            interface{}
+	Archiver      Archiver
+	Verbose       int
+	Names         []string
+	Paths         []string
+	JSON          bool
+	Root          string
+	Strip         int
+	Absolute      bool
+	Transforms    []*Transform
+	NumericOwner  bool
+	SameOwner     bool
+	AllXattrs     bool
+	Policy        Policy
+	Backup        string
+	Transactional bool
+	Command       string
+	Private       *KeyContainer
+	Public        *KeyContainer
+	SlotKey       *SlotKey
+	Slot          int
+	Cost          *Cost
+	Log           io.Writer
+	warned        map[string]bool
+	uids          map[string]int
+	gids          map[string]int
+	stage         string
+	secrets       io.Closer
 }
 
 func main() {
 	c, err := NewCommand()
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	defer c.Close()
 
 	switch op := c.Op.(type) {
 	case func(*archive.Writer, ...string) error:
 		arc := c.createArchive()
 		err = op(arc.Writer, c.Names...)
-		defer arc.Close()
+		if err == nil {
+			err = arc.Close()
+		}
 	case func(*RegexFilter) error:
 		arc, filter := c.filterArchive()
 		err = op(filter)
+		c.warnDamaged(arc)
 		defer arc.Close()
+	case func(...string) error:
+		err = op(c.Paths...)
+	case func() error:
+		err = op()
 	case func(*KeyContainer, *KeyContainer) error:
 		err = op(c.Public, c.Private)
 		defer c.Public.Close()
 		defer c.Private.Close()
+	case func(*KeySlotArchive) error:
+		arc := c.Archiver.(*KeySlotArchive)
+		err = op(arc)
+		defer arc.Close()
 	}
 
 	if err != nil {
@@ -67,7 +102,44 @@ func (c *Cmd) filterArchive() (*Reader, *RegexFilter) {
 	return arc, f
 }
 
+// warnDamaged reports the shards found to be damaged while reading.
+func (c *Cmd) warnDamaged(arc *Reader) {
+	for _, file := range arc.Damaged() {
+		name := "?"
+		if f, ok := file.(interface {
+			Name() string
+		}); ok {
+			name = f.Name()
+		}
+		fmt.Fprintf(os.Stderr, "archive: shard %s damaged\n", name)
+	}
+}
+
+// warnRemoved reports, once for each prefix, that the prefix has been
+// removed from entry names.
+func (c *Cmd) warnRemoved(prefix string) {
+	if c.warned[prefix] {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "archive: removing leading %s from entry names\n", prefix)
+
+	if c.warned == nil {
+		c.warned = map[string]bool{}
+	}
+	c.warned[prefix] = true
+}
+
+// Close zeroes the passwords and private keys read for the command.
+func (c *Cmd) Close() error {
+	if c.secrets == nil {
+		return nil
+	}
+	return c.secrets.Close()
+}
+
 func (c *Cmd) Fatal(v ...interface{}) {
-	fmt.Println(v...)
+	c.Close()
+	fmt.Fprintln(os.Stderr, v...)
 	os.Exit(1)
 }
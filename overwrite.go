@@ -0,0 +1,162 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Policy decides whether extract replaces existing files.
+type Policy int
+
+const (
+	SkipOld Policy = iota
+	KeepNewer
+	Overwrite
+)
+
+// Backup controls for replaced files.
+const (
+	NoBackup       = ""
+	SimpleBackup   = "simple"
+	NumberedBackup = "numbered"
+)
+
+// create makes an entry at path with mk. When a file already exists
+// at path and the Policy allows replacing it the entry is made at a
+// temporary path in the same directory, the existing file is backed up
+// if required, and the entry is renamed over it. During a transaction
+// the Policy is applied to the file at path but the entry is made in
+// the staging directory.
+func (c *Cmd) create(path string, h *tar.Header, mk func(string) error) error {
+	if c.stage != "" {
+		info, err := os.Lstat(path)
+		switch {
+		case err == nil && info.IsDir() && h.Typeflag == tar.TypeDir:
+		case err == nil && !c.replace(info, h):
+			return &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+		case err != nil && !os.IsNotExist(err):
+			return err
+		}
+		path = c.staged(path)
+	}
+
+	err := mk(path)
+	if !os.IsExist(err) {
+		return err
+	}
+
+	info, lerr := os.Lstat(path)
+	switch {
+	case lerr != nil:
+		return lerr
+	case !c.replace(info, h):
+		return err
+	}
+
+	tmp, err := temp(path)
+	if err != nil {
+		return err
+	}
+
+	if err = mk(tmp); err == nil {
+		if err = c.backup(path); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+
+	// rename does nothing when tmp and path are links to the same file
+	os.Remove(tmp)
+
+	return err
+}
+
+// replace reports whether an existing file is replaced by the entry.
+// Directories are never replaced.
+func (c *Cmd) replace(info os.FileInfo, h *tar.Header) bool {
+	switch {
+	case info.IsDir() || h.Typeflag == tar.TypeDir:
+		return false
+	case c.Policy == KeepNewer:
+		return info.ModTime().Before(h.ModTime)
+	}
+	return c.Policy == Overwrite
+}
+
+// backup keeps a copy of the file at path, as path~ or as path.~N~ for
+// numbered backups, by linking to it or failing that renaming it.
+func (c *Cmd) backup(path string) error {
+	var name string
+	switch c.Backup {
+	case NoBackup:
+		return nil
+	case NumberedBackup:
+		n, err := lastBackup(path)
+		if err != nil {
+			return err
+		}
+		name = fmt.Sprintf("%s.~%d~", path, n+1)
+	default:
+		name = path + "~"
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Link(path, name); err != nil {
+		return os.Rename(path, name)
+	}
+
+	return nil
+}
+
+// lastBackup returns the highest number of the numbered backups of the
+// file at path.
+func lastBackup(path string) (int, error) {
+	names, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := filepath.Base(path) + ".~"
+	last := 0
+
+	for _, info := range names {
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "~") {
+			continue
+		}
+
+		number := strings.TrimSuffix(strings.TrimPrefix(name, prefix), "~")
+		if n, err := strconv.Atoi(number); err == nil && n > last {
+			last = n
+		}
+	}
+
+	return last, nil
+}
+
+// temp returns an unused path in the same directory as path.
+func temp(path string) (string, error) {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+
+		name := fmt.Sprintf(".%s.%s", filepath.Base(path), hex.EncodeToString(b[:]))
+		tmp := filepath.Join(filepath.Dir(path), name)
+
+		if _, err := os.Lstat(tmp); os.IsNotExist(err) {
+			return tmp, nil
+		}
+	}
+}
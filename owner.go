@@ -0,0 +1,110 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// owner returns the user and group IDs to give an extracted entry. The
+// user and group are looked up by name unless numeric IDs are required
+// or the name is unknown, in which case the stored IDs are used.
+func (c *Cmd) owner(h *tar.Header) (int, int) {
+	uid, gid := h.Uid, h.Gid
+
+	if c.NumericOwner {
+		return uid, gid
+	}
+
+	if c.uids == nil {
+		c.uids = map[string]int{}
+		c.gids = map[string]int{}
+	}
+
+	if id, ok := lookupID(c.uids, h.Uname, userID); ok {
+		uid = id
+	}
+
+	if id, ok := lookupID(c.gids, h.Gname, groupID); ok {
+		gid = id
+	}
+
+	return uid, gid
+}
+
+// lookupID returns the ID of the named user or group, caching the
+// result of each lookup with -1 for unknown names.
+func lookupID(ids map[string]int, name string, find func(string) (string, error)) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+
+	if id, ok := ids[name]; ok {
+		return id, id >= 0
+	}
+
+	ids[name] = -1
+	s, err := find(name)
+	if err != nil {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+
+	ids[name] = id
+	return id, true
+}
+
+func userID(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func groupID(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// chown gives an extracted entry its stored owner, then restores any
+// setuid and setgid bits that changing the owner cleared, which for
+// directories is left to their later fixup.
+func (c *Cmd) chown(path string, h *tar.Header) error {
+	uid, gid := c.owner(h)
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return err
+	}
+
+	if h.Typeflag != tar.TypeSymlink && h.Typeflag != tar.TypeDir && h.Mode&(04000|02000) != 0 {
+		return os.Chmod(path, os.FileMode(h.Mode).Perm()|setid(h.Mode))
+	}
+
+	return nil
+}
+
+// setid returns the os.FileMode setuid, setgid and sticky bits of a tar
+// header mode.
+func setid(mode int64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
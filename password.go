@@ -0,0 +1,187 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("passwords don't match")
+	ErrNoTerminal       = errors.New("no terminal to read the password from")
+	ErrOnePassword      = errors.New("--password-file, --password-fd, --password-env, and --password-command supply only the first password, others must be entered on a terminal")
+)
+
+// ttyPath is the path of the controlling terminal.
+var ttyPath = "/dev/tty"
+
+// ReadPassword reads a password from the source given by --password-file,
+// --password-fd, --password-env, or --password-command, or otherwise
+// prompts for it on the terminal and, when confirm is true, prompts for
+// it a second time and requires both to match. A source supplies only
+// the first password read, which is the first line of the file,
+// descriptor, which is then closed, or command output, or the value of
+// the environment variable, and any other is read from the terminal or fails with
+// ErrOnePassword when there is none. Every password read is held until
+// the Args are closed.
+func (a *Args) ReadPassword(prompt string, confirm bool) (*Secret, error) {
+	password, err := a.readPassword(prompt, confirm)
+	if err == nil {
+		a.passwords = append(a.passwords, password)
+	}
+	return password, err
+}
+
+// Close zeroes every password read and private key loaded.
+func (a *Args) Close() error {
+	for _, password := range a.passwords {
+		password.Close()
+	}
+	for _, key := range a.keys {
+		key.Close()
+	}
+	a.passwords = nil
+	a.keys = nil
+	return nil
+}
+
+func (a *Args) readPassword(prompt string, confirm bool) (*Secret, error) {
+	switch {
+	case a.PasswordSources() == 0:
+		return readConfirmed(prompt, confirm)
+	case len(a.passwords) > 0:
+		password, err := readConfirmed(prompt, confirm)
+		if err == ErrNoTerminal {
+			err = ErrOnePassword
+		}
+		return password, err
+	}
+
+	switch {
+	case a.PasswordFile != "":
+		f, err := os.Open(a.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readLine(f)
+	case a.PasswordFD >= 0:
+		f := os.NewFile(uintptr(a.PasswordFD), "password")
+		defer f.Close()
+		return readLine(f)
+	case a.PasswordEnv != "":
+		value, ok := os.LookupEnv(a.PasswordEnv)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", a.PasswordEnv)
+		}
+		os.Unsetenv(a.PasswordEnv)
+		return CopySecret([]byte(value)), nil
+	default:
+		cmd := shell(a.PasswordCommand)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("--password-command: %s", err)
+		}
+		defer zero(out)
+		return readLine(bytes.NewReader(out))
+	}
+}
+
+// PasswordSources returns the number of password sources specified.
+func (a *Args) PasswordSources() int {
+	sources := []bool{a.PasswordFile != "", a.PasswordFD >= 0, a.PasswordEnv != "", a.PasswordCommand != ""}
+	n := 0
+	for _, source := range sources {
+		n += btoi(source)
+	}
+	return n
+}
+
+// readLine reads up to the first newline one byte at a time, so that
+// nothing beyond the password is consumed from a shared descriptor,
+// and removes the line ending. The line is read into a Secret that is
+// replaced by one twice the size whenever it fills.
+func readLine(r io.Reader) (*Secret, error) {
+	line := NewSecret(64)
+	n := 0
+
+	for {
+		b := line.Bytes()
+		if n == len(b) {
+			grown := NewSecret(2 * n)
+			copy(grown.Bytes(), b)
+			line.Close()
+			line, b = grown, grown.Bytes()
+		}
+
+		m, err := r.Read(b[n : n+1])
+		switch {
+		case m > 0 && b[n] != '\n':
+			n++
+			continue
+		case m == 0 && err == nil:
+			continue
+		case err != nil && err != io.EOF:
+			line.Close()
+			return nil, err
+		}
+
+		if n > 0 && b[n-1] == '\r' {
+			n--
+		}
+		line.truncate(n)
+		return line, nil
+	}
+}
+
+// readConfirmed prompts for a password on the terminal and, if confirm
+// is true, for the same password again.
+func readConfirmed(prompt string, confirm bool) (*Secret, error) {
+	password, err := ReadPassword(prompt)
+	if err != nil || !confirm {
+		return password, err
+	}
+
+	again, err := ReadPassword("confirm " + prompt)
+	if err != nil {
+		password.Close()
+		return nil, err
+	}
+	defer again.Close()
+
+	if !password.Equal(again) {
+		password.Close()
+		return nil, ErrPasswordMismatch
+	}
+
+	return password, nil
+}
+
+// ReadPassword prompts for a password on stderr and reads it from the
+// terminal, which is opened directly when stdin has been redirected.
+func ReadPassword(prompt string) (*Secret, error) {
+	tty := os.Stdin
+	if !terminal.IsTerminal(int(tty.Fd())) {
+		f, err := os.Open(ttyPath)
+		if err != nil {
+			return nil, ErrNoTerminal
+		}
+		defer f.Close()
+		tty = f
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprint(os.Stderr, "\n")
+	if err != nil {
+		return nil, err
+	}
+	return CopySecret(b), nil
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("secret\r\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("ARC_TEST_PASSWORD", "secret")
+
+	tests := []PasswordOptions{
+		{PasswordFile: path, PasswordFD: -1},
+		{PasswordEnv: "ARC_TEST_PASSWORD", PasswordFD: -1},
+		{PasswordCommand: "echo secret; echo ignored", PasswordFD: -1},
+	}
+
+	for _, test := range tests {
+		args := &Args{PasswordOptions: test}
+		password, err := args.ReadPassword("password: ", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(password.Bytes(), []byte("secret")) {
+			t.Fatalf("%+v: expected secret got %q", test, password.Bytes())
+		}
+	}
+
+	if _, ok := os.LookupEnv("ARC_TEST_PASSWORD"); ok {
+		t.Fatal("password left in the environment")
+	}
+
+	args := &Args{PasswordOptions: PasswordOptions{PasswordEnv: "ARC_TEST_PASSWORD", PasswordFD: -1}}
+	if _, err := args.ReadPassword("password: ", false); err == nil {
+		t.Fatal("expected error for unset environment variable")
+	}
+
+	args = &Args{PasswordOptions: PasswordOptions{PasswordCommand: "exit 1", PasswordFD: -1}}
+	if _, err := args.ReadPassword("password: ", false); err == nil {
+		t.Fatal("expected error for failed command")
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	long := bytes.Repeat([]byte("secret"), 100)
+
+	tests := []struct {
+		input string
+		line  []byte
+	}{
+		{"", []byte{}},
+		{"\n", []byte{}},
+		{"secret", []byte("secret")},
+		{"secret\r\n", []byte("secret")},
+		{string(long) + "\nignored", long},
+	}
+
+	for _, test := range tests {
+		r := bytes.NewReader([]byte(test.input))
+		line, err := readLine(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(line.Bytes(), test.line) {
+			t.Fatalf("%q: expected %q got %q", test.input, test.line, line.Bytes())
+		}
+	}
+}
+
+func TestSecondPasswordWithoutTerminal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	defer func(f *os.File, path string) {
+		os.Stdin, ttyPath = f, path
+	}(os.Stdin, ttyPath)
+	os.Stdin, ttyPath = stdin, filepath.Join(dir, "tty")
+
+	os.Setenv("ARC_TEST_PASSWORD", "secret")
+	args := &Args{PasswordOptions: PasswordOptions{PasswordEnv: "ARC_TEST_PASSWORD", PasswordFD: -1}}
+	defer args.Close()
+
+	if _, err := args.ReadPassword("password: ", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := args.ReadPassword("new password: ", true); err != ErrOnePassword {
+		t.Fatalf("expected %v got %v", ErrOnePassword, err)
+	}
+
+	args = &Args{PasswordOptions: PasswordOptions{PasswordFD: -1}}
+	if _, err := args.ReadPassword("password: ", false); err != ErrNoTerminal {
+		t.Fatalf("expected %v got %v", ErrNoTerminal, err)
+	}
+}
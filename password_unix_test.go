@@ -0,0 +1,44 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestPasswordFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.Write([]byte("secret\nignored"))
+	w.Close()
+
+	// the password descriptor is closed once read, so give it a copy
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := &Args{PasswordOptions: PasswordOptions{PasswordFD: fd}}
+	password, err := args.ReadPassword("password: ", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer args.Close()
+
+	if !bytes.Equal(password.Bytes(), []byte("secret")) {
+		t.Fatalf("expected secret got %q", password.Bytes())
+	}
+
+	if _, err := syscall.Dup(fd); err != syscall.EBADF {
+		t.Fatal("password descriptor not closed", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/wg/arc/archive"
+)
+
+var (
+	ErrCommandFailed = errors.New("archive: command failed")
+)
+
+// ToStdout writes the content of each regular file to stdout, before
+// the archive is verified, and lists entries on stderr.
+func (c *Cmd) ToStdout(arc *RegexFilter) error {
+	for arc.Next() {
+		h := arc.Header
+
+		if _, ok := c.rename(h.Name); !ok || !regular(h) {
+			continue
+		}
+
+		if c.Verbose > 0 {
+			fmt.Fprintln(os.Stderr, "x", h.Name)
+		}
+
+		switch n, err := io.Copy(os.Stdout, arc); {
+		case err != nil:
+			return err
+		case n < h.Size:
+			return archive.ErrShortCopy
+		}
+	}
+
+	switch {
+	case arc.Error != nil:
+		return arc.Error
+	case !arc.Verify():
+		return ErrVerifyFailed
+	}
+
+	return nil
+}
+
+// ToCommand runs the command with the shell for each entry, with the
+// entry's content on stdin and its metadata in the TAR_ environment
+// variables GNU tar sets. The command's output is passed through to
+// stdout so entries are listed on stderr. Failures are reported and
+// counted.
+func (c *Cmd) ToCommand(arc *RegexFilter) error {
+	failed := 0
+
+	for arc.Next() {
+		h := arc.Header
+
+		name, ok := c.rename(h.Name)
+		if !ok {
+			continue
+		}
+
+		if c.Verbose > 0 {
+			fmt.Fprintln(os.Stderr, "x", h.Name)
+		}
+
+		cmd := shell(c.Command)
+		cmd.Env = append(os.Environ(), environ(name, h)...)
+		cmd.Stdin = arc
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", h.Name, err)
+			failed++
+		}
+	}
+
+	switch {
+	case arc.Error != nil:
+		return arc.Error
+	case !arc.Verify():
+		return ErrVerifyFailed
+	case failed > 0:
+		return ErrCommandFailed
+	}
+
+	return nil
+}
+
+// shell returns a command running the command line with the shell.
+func shell(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// environ returns the environment describing an entry extracted with
+// the given name.
+func environ(name string, h *tar.Header) []string {
+	types := map[byte]string{
+		tar.TypeDir:     "d",
+		tar.TypeSymlink: "l",
+		tar.TypeLink:    "h",
+		tar.TypeChar:    "c",
+		tar.TypeBlock:   "b",
+		tar.TypeFifo:    "p",
+	}
+
+	filetype, ok := types[h.Typeflag]
+	if !ok {
+		filetype = "f"
+	}
+
+	return []string{
+		"TAR_FILETYPE=" + filetype,
+		"TAR_FILENAME=" + name,
+		"TAR_REALNAME=" + h.Name,
+		"TAR_MODE=" + fmt.Sprintf("%04o", h.Mode&07777),
+		"TAR_UNAME=" + h.Uname,
+		"TAR_GNAME=" + h.Gname,
+		"TAR_UID=" + strconv.Itoa(h.Uid),
+		"TAR_GID=" + strconv.Itoa(h.Gid),
+		"TAR_SIZE=" + strconv.FormatInt(h.Size, 10),
+		"TAR_MTIME=" + strconv.FormatInt(h.ModTime.Unix(), 10),
+	}
+}
+
+// regular reports whether the header is for a regular file.
+func regular(h *tar.Header) bool {
+	switch h.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		return true
+	}
+	return false
+}
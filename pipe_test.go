@@ -0,0 +1,145 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestToStdout(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "dir", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "dir/a", Typeflag: tar.TypeReg, Mode: 0600, Size: 5},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/a"},
+		{Name: "b", Typeflag: tar.TypeReg, Mode: 0600, Size: 1},
+	})
+
+	out, err := os.Create(filepath.Join(root, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	r, err := arc.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRegexFilter(r.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Cmd{Verbose: 1}).ToStdout(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, _ := ioutil.ReadDir(root); len(names) != 1 {
+		t.Fatal("entries written to filesystem")
+	}
+
+	if b, err := ioutil.ReadFile(out.Name()); err != nil || string(b) != "dir/ab" {
+		t.Fatalf("wrote %q to stdout", b)
+	}
+}
+
+func TestToCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command uses /bin/sh")
+	}
+
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "top/dir", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "top/dir/a", Typeflag: tar.TypeReg, Mode: 0640, Uid: 7},
+		{Name: "top/b", Typeflag: tar.TypeReg, Mode: 0600},
+	})
+
+	log := filepath.Join(root, "log")
+	c := &Cmd{
+		Strip:   1,
+		Command: "echo $TAR_FILETYPE $TAR_FILENAME $TAR_MODE $TAR_UID >> " + log + "; test $TAR_FILENAME != b",
+	}
+
+	r, err := arc.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRegexFilter(r.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ToCommand(f); err != ErrCommandFailed {
+		t.Fatal("expected command failure got", err)
+	}
+
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	expected := []string{"d dir 0700 0", "f dir/a 0640 7", "f b 0600 0"}
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Fatalf("command ran with %q", lines)
+	}
+}
+
+func TestToCommandStdout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command uses /bin/sh")
+	}
+
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	arc := extractArchive(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0600, Size: 3},
+		{Name: "b", Typeflag: tar.TypeReg, Mode: 0600, Size: 2},
+	})
+
+	out, err := os.Create(filepath.Join(root, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	r, err := arc.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRegexFilter(r.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Cmd{Verbose: 1, Command: "cat"}).ToCommand(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(out.Name()); err != nil || string(b) != "aaabb" {
+		t.Fatalf("wrote %q to stdout", b)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"crypto/subtle"
+)
+
+// A Secret holds a password or key in memory that is locked, where the
+// host allows it, so it is never written to swap and is zeroed when the
+// Secret is closed. A nil Secret holds nothing.
+type Secret struct {
+	b      []byte
+	mem    []byte
+	mapped bool
+}
+
+// NewSecret returns a Secret of size bytes, all zero.
+func NewSecret(size int) *Secret {
+	if mem := lock(size); mem != nil {
+		return &Secret{b: mem[:size], mem: mem, mapped: true}
+	}
+	mem := make([]byte, size)
+	return &Secret{b: mem, mem: mem}
+}
+
+// CopySecret returns a Secret holding a copy of b and zeroes b.
+func CopySecret(b []byte) *Secret {
+	s := NewSecret(len(b))
+	copy(s.b, b)
+	zero(b)
+	return s
+}
+
+// Bytes returns the secret, which is only valid until Close.
+func (s *Secret) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	return s.b
+}
+
+// Equal reports whether two secrets hold the same bytes.
+func (s *Secret) Equal(other *Secret) bool {
+	return subtle.ConstantTimeCompare(s.Bytes(), other.Bytes()) == 1
+}
+
+// Close zeroes the secret and releases its memory. It is safe to close
+// a Secret more than once.
+func (s *Secret) Close() error {
+	if s == nil || s.mem == nil {
+		return nil
+	}
+
+	zero(s.mem)
+	if s.mapped {
+		unlock(s.mem)
+	}
+	s.b, s.mem = nil, nil
+
+	return nil
+}
+
+// truncate shortens the secret to n bytes.
+func (s *Secret) truncate(n int) {
+	zero(s.b[n:])
+	s.b = s.b[:n]
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// madvDontDump excludes pages from core dumps, it is missing from the
+// syscall package on some architectures.
+const madvDontDump = 0x10
+
+// lock returns size bytes of memory mapped outside the Go heap, so the
+// runtime never copies it, that is excluded from core dumps and locked
+// in RAM when RLIMIT_MEMLOCK allows. It returns nil when the memory
+// can't be mapped.
+func lock(size int) []byte {
+	page := os.Getpagesize()
+	length := (size + page - 1) &^ (page - 1)
+	if length == 0 {
+		length = page
+	}
+
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	flags := syscall.MAP_ANON | syscall.MAP_PRIVATE
+	mem, err := syscall.Mmap(-1, 0, length, prot, flags)
+	if err != nil {
+		return nil
+	}
+
+	syscall.Madvise(mem, madvDontDump)
+	syscall.Mlock(mem)
+
+	return mem
+}
+
+// unlock releases memory returned by lock.
+func unlock(mem []byte) {
+	syscall.Munlock(mem)
+	syscall.Munmap(mem)
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	b := []byte("secret")
+	s := CopySecret(b)
+
+	switch {
+	case !bytes.Equal(s.Bytes(), []byte("secret")):
+		t.Fatal("secret not copied")
+	case !bytes.Equal(b, make([]byte, len(b))):
+		t.Fatal("copied bytes not zeroed")
+	case !s.Equal(secret("secret")) || s.Equal(secret("terces")):
+		t.Fatal("secret comparison incorrect")
+	}
+
+	mem, mapped := s.mem, s.mapped
+	s.Close()
+	s.Close()
+
+	switch {
+	case s.Bytes() != nil:
+		t.Fatal("closed secret still holds bytes")
+	case !mapped && !bytes.Equal(mem, make([]byte, len(mem))):
+		t.Fatal("closed secret not zeroed")
+	}
+
+	if len(NewSecret(0).Bytes()) != 0 || len(NewSecret(5000).Bytes()) != 5000 {
+		t.Fatal("secret size incorrect")
+	}
+
+	var empty *Secret
+	if empty.Bytes() != nil || empty.Close() != nil {
+		t.Fatal("nil secret holds bytes")
+	}
+}
+
+func TestPrivateKeySecrets(t *testing.T) {
+	a := &Args{}
+	private := a.NewPrivateKey()
+	signing := a.NewSigningPrivateKey()
+
+	_, generated := keypair(t)
+	copy(private[:], generated[:])
+	signing[0] = 1
+
+	keys := a.keys
+	switch {
+	case len(keys) != 2:
+		t.Fatal("private keys not held in secrets")
+	case !bytes.Equal(keys[0].Bytes(), generated[:]) || keys[1].Bytes()[0] != 1:
+		t.Fatal("private key not stored in its secret")
+	}
+
+	a.Close()
+
+	for _, key := range keys {
+		if key.Bytes() != nil {
+			t.Fatal("private key secret not closed")
+		}
+	}
+}
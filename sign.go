@@ -0,0 +1,135 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"hash"
+
+	"github.com/dchest/blake2b"
+)
+
+const (
+	SigningPublic  = 0x03
+	SigningPrivate = 0x04
+	SignatureSize  = ed25519.SignatureSize
+)
+
+type (
+	SigningPublicKey  [ed25519.PublicKeySize]byte
+	SigningPrivateKey [ed25519.SeedSize]byte
+)
+
+var (
+	ErrInvalidFlags     = errors.New("archive: unsupported flags")
+	ErrNotSigned        = errors.New("archive: not signed")
+	ErrInvalidSignature = errors.New("archive: signature invalid")
+)
+
+// Signing holds the Ed25519 private key used to sign an archive when
+// writing and the public key that must have signed it when reading.
+// The signature covers the header digest and the digest of all the
+// encrypted data, and is appended to the end of the archive.
+type Signing struct {
+	SigningKey *SigningPrivateKey
+	Signer     *SigningPublicKey
+	signed     bool
+}
+
+func GenerateSigningKeypair() (*SigningPublicKey, *SigningPrivateKey, error) {
+	var public SigningPublicKey
+	var private SigningPrivateKey
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	copy(public[:], publicKey)
+	copy(private[:], privateKey.Seed())
+
+	return &public, &private, nil
+}
+
+// SetSigning sets the keys used to sign or verify the archive.
+func (s *Signing) SetSigning(key *SigningPrivateKey, signer *SigningPublicKey) {
+	s.SigningKey = key
+	s.Signer = signer
+}
+
+// flags returns the header flags for an archive being written.
+func (s *Signing) flags() byte {
+	if s.SigningKey != nil {
+		return Signed
+	}
+	return 0
+}
+
+// check checks the header flags of an archive being read, failing
+// before any key derivation if a signature is required but missing.
+func (s *Signing) check(flags byte) error {
+	switch {
+	case flags&^(Signed|Dispersed) != 0:
+		return ErrInvalidFlags
+	case s.Signer != nil && flags&Signed == 0:
+		return ErrNotSigned
+	}
+	s.signed = flags&Signed != 0
+	return nil
+}
+
+func (private *SigningPrivateKey) Zero() {
+	zero(private[:])
+}
+
+// A signature is the archive.Trailer that signs an archive with the
+// SigningKey when written and, when a Signer is given, verifies it when
+// read. Only authenticated chunks are digested.
+type signature struct {
+	*Signing
+	ad   []byte
+	hash hash.Hash
+}
+
+func newSignature(s *Signing, ad []byte) *signature {
+	return &signature{
+		Signing: s,
+		ad:      ad,
+		hash:    blake2b.New256(),
+	}
+}
+
+func (s *signature) Write(b []byte) (int, error) {
+	return s.hash.Write(b)
+}
+
+func (s *signature) Size() int {
+	return SignatureSize
+}
+
+func (s *signature) Seal() []byte {
+	private := ed25519.NewKeyFromSeed(s.SigningKey[:])
+	defer zero(private)
+	return ed25519.Sign(private, message(s.ad, s.hash))
+}
+
+func (s *signature) Open(signature []byte) error {
+	if s.Signer == nil {
+		return nil
+	}
+
+	public := ed25519.PublicKey(s.Signer[:])
+	if !ed25519.Verify(public, message(s.ad, s.hash), signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// message returns the signed message, the header digest followed by the
+// digest of the encrypted data.
+func message(ad []byte, hash hash.Hash) []byte {
+	return hash.Sum(append([]byte{}, ad...))
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/wg/arc/archive"
+)
+
+func TestSignedArchive(t *testing.T) {
+	public, private := keypair(t)
+	signer, key := signingKeypair(t)
+
+	archives := []Archiver{
+		NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{}),
+		NewCurve448Archive([]*PublicKey{public}, private, &Buffer{}),
+		NewShardArchive(2, buffers(3)),
+		NewKeySlotArchive(slotKeys(secret("secret"), nil), secret("secret"), nil, &Buffer{}),
+	}
+
+	for _, arc := range archives {
+		arc.SetSigning(key, nil)
+		dat := createArchive(t, arc)
+		arc.SetSigning(nil, signer)
+		verifyArchive(t, arc, dat)
+	}
+}
+
+func TestSignedArchiveFormat(t *testing.T) {
+	signer, key := signingKeypair(t)
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+	arc.SetSigning(key, nil)
+	createArchive(t, arc)
+
+	if buf.buffer[2] != Signed {
+		t.Fatal("serialized flags incorrect")
+	}
+
+	buf = &Buffer{}
+	arc = NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+	dat := createArchive(t, arc)
+
+	if buf.buffer[2] != 0 {
+		t.Fatal("serialized flags incorrect")
+	}
+
+	arc.SetSigning(nil, signer)
+	if _, err := arc.Reader(); err != ErrNotSigned {
+		t.Fatal("expected not signed got", err)
+	}
+
+	buf.Rewind()
+	arc.SetSigning(nil, nil)
+	verifyArchive(t, arc, dat)
+}
+
+func TestUnverifiedSignedArchive(t *testing.T) {
+	_, key := signingKeypair(t)
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+	arc.SetSigning(key, nil)
+	dat := createArchive(t, arc)
+	arc.SetSigning(nil, nil)
+	verifyArchive(t, arc, dat)
+}
+
+func TestWrongSigner(t *testing.T) {
+	_, key := signingKeypair(t)
+	signer, _ := signingKeypair(t)
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+	arc.SetSigning(key, nil)
+	createArchive(t, arc)
+	arc.SetSigning(nil, signer)
+
+	if err := readArchive(t, arc); err != ErrInvalidSignature {
+		t.Fatal("expected invalid signature got", err)
+	}
+}
+
+func TestTamperedSignature(t *testing.T) {
+	signer, key := signingKeypair(t)
+	buf := &Buffer{}
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, buf)
+	arc.SetSigning(key, nil)
+	createArchive(t, arc)
+	arc.SetSigning(nil, signer)
+
+	buf.buffer[len(buf.buffer)-1] ^= 0xff
+
+	if err := readArchive(t, arc); err != ErrInvalidSignature {
+		t.Fatal("expected invalid signature got", err)
+	}
+
+	buf.Rewind()
+	buf.buffer = buf.buffer[:len(buf.buffer)-SignatureSize]
+
+	if err := readArchive(t, arc); err != ErrInvalidArchive {
+		t.Fatal("expected invalid archive got", err)
+	}
+}
+
+func TestWrongSignerExtract(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	_, key := signingKeypair(t)
+	signer, _ := signingKeypair(t)
+
+	data := make([]byte, 2*archive.ChunkSize)
+	rand.Read(data)
+
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+	arc.SetSigning(key, nil)
+
+	w, err := arc.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		h := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data))}
+		if err := w.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Copy(bytes.NewReader(data), h.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rewind(arc)
+	arc.SetSigning(nil, signer)
+
+	c := &Cmd{Root: root, Transactional: true}
+	if err := extractEntries(t, c, arc); err == nil {
+		t.Fatal("extracted archive with wrong signer")
+	}
+
+	if names, _ := ioutil.ReadDir(root); len(names) != 0 {
+		t.Fatal("entries left after signer mismatch", len(names))
+	}
+}
+
+func TestSigningKeypair(t *testing.T) {
+	public, private := signingKeypair(t)
+
+	b := &Buffer{}
+	puc := NewKeyContainer(b, nil, 1, 8, 1)
+	if err := puc.WriteSigningPublicKey(public); err != nil {
+		t.Fatal("failed to store public key", err)
+	}
+	b.Rewind()
+
+	loaded := &SigningPublicKey{}
+	if err := puc.ReadSigningPublicKey(loaded); err != nil {
+		t.Fatal("failed to load public key", err)
+	}
+
+	b.Rewind()
+	if err := puc.ReadPublicKey(&PublicKey{}); err != ErrInvalidPublicKey {
+		t.Fatal("loaded signing public key as public key")
+	}
+
+	_, prc := StorePrivateKey(t, &PrivateKey{})
+	if err := prc.ReadSigningPrivateKey(private); err != ErrInvalidPrivateKey {
+		t.Fatal("loaded private key as signing private key")
+	}
+
+	if !bytes.Equal(public[:], loaded[:]) {
+		t.Fatal("serialized key incorrect")
+	}
+}
+
+func readArchive(t *testing.T, a Archiver) error {
+	reader, err := a.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		switch _, err := reader.Next(); {
+		case err == io.EOF:
+			if !reader.Verify() {
+				return ErrInvalidArchive
+			}
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if _, err := ioutil.ReadAll(reader); err != nil {
+			return err
+		}
+	}
+}
+
+func signingKeypair(t *testing.T) (*SigningPublicKey, *SigningPrivateKey) {
+	public, private, err := GenerateSigningKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
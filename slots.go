@@ -0,0 +1,83 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/wg/arc/argon2"
+)
+
+func (c *Cmd) AddSlot(arc *KeySlotArchive) error {
+	key, err := arc.Unlock()
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	i, err := arc.Add(key, *c.SlotKey)
+	if err != nil {
+		return err
+	}
+
+	if err = arc.Rewrite(); err != nil {
+		return err
+	}
+
+	if c.Verbose > 0 {
+		fmt.Println("+", slot(i, &arc.Slots[i]))
+	}
+
+	return nil
+}
+
+func (c *Cmd) RemoveSlot(arc *KeySlotArchive) error {
+	key, err := arc.Unlock()
+	if err != nil {
+		return err
+	}
+	key.Close()
+
+	i := c.Slot - 1
+	s := arc.Slots[i]
+
+	if err := arc.Remove(i); err != nil {
+		return err
+	}
+
+	if err := arc.Rewrite(); err != nil {
+		return err
+	}
+
+	if c.Verbose > 0 {
+		fmt.Println("-", slot(i, &s))
+	}
+
+	return nil
+}
+
+func (c *Cmd) ListSlots(arc *KeySlotArchive) error {
+	if err := arc.ReadHeader(); err != nil {
+		return err
+	}
+
+	for i := range arc.Slots {
+		if arc.Slots[i].Type != Empty || c.Verbose > 0 {
+			fmt.Println(slot(i, &arc.Slots[i]))
+		}
+	}
+
+	return nil
+}
+
+func slot(i int, s *KeySlot) string {
+	switch s.Type {
+	case Password:
+		memory := ByteSize(s.Memory) * KB
+		return fmt.Sprintf("%d  password  %s, %d iterations, %s memory, %d lanes",
+			i+1, argon2.Variant(s.Variant), s.Iterations, memory, s.Lanes)
+	case Curve448:
+		return fmt.Sprintf("%d  curve448", i+1)
+	}
+	return fmt.Sprintf("%d  empty", i+1)
+}
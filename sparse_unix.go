@@ -0,0 +1,68 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+//go:build linux || freebsd
+// +build linux freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/wg/arc/archive"
+)
+
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataRegions returns the regions of the file holding data, found with
+// SEEK_DATA and SEEK_HOLE, or nil if the file has no holes.
+func dataRegions(f *os.File, size int64) ([]archive.Region, error) {
+	var regions []archive.Region
+	data := int64(0)
+
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		switch {
+		case errno(err) == syscall.ENXIO:
+			offset = size
+			continue
+		case errno(err) == syscall.EINVAL:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+
+		if end > size {
+			end = size
+		}
+
+		regions = append(regions, archive.Region{Offset: start, Length: end - start})
+		data += end - start
+		offset = end
+	}
+
+	if data == size {
+		return nil, nil
+	}
+
+	if n := len(regions); n == 0 || regions[n-1].Offset+regions[n-1].Length < size {
+		regions = append(regions, archive.Region{Offset: size})
+	}
+
+	return regions, nil
+}
+
+func errno(err error) error {
+	if e, ok := err.(*os.PathError); ok {
+		return e.Err
+	}
+	return err
+}
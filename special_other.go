@@ -0,0 +1,20 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+)
+
+var errSpecial = errors.New("devices and FIFOs not supported")
+
+// mknod creates the device or FIFO described by the header at path,
+// which is not supported on this platform.
+func mknod(path string, h *tar.Header) error {
+	return &os.PathError{Op: "mknod", Path: path, Err: errSpecial}
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"syscall"
+)
+
+// mknod creates the device or FIFO described by the header at path.
+func mknod(path string, h *tar.Header) error {
+	mode := uint32(h.Mode & 07777)
+
+	var err error
+	switch h.Typeflag {
+	case tar.TypeChar:
+		err = syscall.Mknod(path, mode|syscall.S_IFCHR, mkdev(h.Devmajor, h.Devminor))
+	case tar.TypeBlock:
+		err = syscall.Mknod(path, mode|syscall.S_IFBLK, mkdev(h.Devmajor, h.Devminor))
+	case tar.TypeFifo:
+		err = syscall.Mkfifo(path, mode)
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFifo(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0600); err != nil {
+		t.Skip("FIFOs not supported", err)
+	}
+
+	headers := scanHeaders(t, root, "fifo")
+	if headers[0].Typeflag != tar.TypeFifo {
+		t.Fatal("FIFO not stored as FIFO")
+	}
+
+	arc := extractArchive(t, headers)
+	dest := filepath.Join(root, "dest")
+	if err := extractEntries(t, &Cmd{Root: dest}, arc); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(filepath.Join(dest, "fifo"))
+	if err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatal("FIFO not extracted")
+	}
+}
+
+func TestSparseFile(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	data := make([]byte, 1<<20)
+	copy(data[1<<19:], "data")
+
+	f, err := os.Create(filepath.Join(root, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(data[1<<19:1<<19+4], 1<<19)
+	f.Truncate(int64(len(data)))
+
+	regions, err := dataRegions(f, int64(len(data)))
+	f.Close()
+
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case regions == nil:
+		t.Skip("holes not supported")
+	}
+
+	arc := NewPasswordArchive(secret("secret"), 1, 8, 1, &Buffer{})
+	w, err := arc.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cmd{Root: root, Log: ioutil.Discard}
+	if err := c.Create(w.Writer, "sparse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rewind(arc)
+
+	dest := filepath.Join(root, "dest")
+	if err := extractEntries(t, &Cmd{Root: dest}, arc); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dest, "sparse")
+	if b, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(b, data) {
+		t.Fatal("sparse file content mismatch", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blocks := info.Sys().(*syscall.Stat_t).Blocks; blocks*512 >= int64(len(data)) {
+		t.Fatal("sparse file extracted without holes")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A transactional extraction writes entries to a staging directory in
+// the destination and moves them into place only once the archive is
+// verified, so an archive that fails verification leaves nothing behind.
+
+// begin creates the staging directory.
+func (c *Cmd) begin() error {
+	stage, err := ioutil.TempDir(c.Root, ".arc-")
+	if err != nil {
+		return err
+	}
+	c.stage = stage
+	return nil
+}
+
+// rollback removes the staging directory and anything left in it.
+func (c *Cmd) rollback() {
+	os.RemoveAll(c.stage)
+	c.stage = ""
+}
+
+// staged returns the path an entry with destination path is written to,
+// which is under the staging directory during a transaction.
+func (c *Cmd) staged(path string) string {
+	if c.stage == "" {
+		return path
+	}
+
+	rel, err := filepath.Rel(c.Root, path)
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(c.stage, rel)
+}
+
+// commit moves each staged entry into place. Staged directories are
+// moved whole unless a directory already exists in their place, which
+// their contents are then merged into, and other entries replace any
+// existing file, backing it up if required.
+func (c *Cmd) commit() error {
+	return filepath.Walk(c.stage, func(staged string, info os.FileInfo, err error) error {
+		if err != nil || staged == c.stage {
+			return err
+		}
+
+		rel, err := filepath.Rel(c.stage, staged)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(c.Root, rel)
+
+		existing, err := os.Lstat(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		case info.IsDir() && existing.IsDir():
+			return nil
+		case !info.IsDir():
+			if err := c.backup(path); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Rename(staged, path); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
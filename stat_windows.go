@@ -0,0 +1,19 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"os"
+)
+
+// linkID returns the ID of a file with more than one hard link, which
+// is not available on Windows.
+func linkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// fileOwner returns the user and group IDs of a file, which are not
+// available on Windows.
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidTransform = errors.New("invalid transform, expected s/regexp/replacement/flags")
+)
+
+// A Transform rewrites names with a sed-style s/regexp/replacement/flags
+// expression, where regexp uses extended syntax as with sed -E. Any
+// character may delimit the parts and is escaped with a backslash. The
+// replacement may refer to the match with & and to submatches with \1
+// through \9, and the flags are g to replace every match rather than
+// the first and i to match without regard to case.
+type Transform struct {
+	regex   *regexp.Regexp
+	replace string
+	global  bool
+}
+
+func ParseTransform(expr string) (*Transform, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return nil, ErrInvalidTransform
+	}
+
+	parts := split(expr[2:], expr[1])
+	if len(parts) != 3 {
+		return nil, ErrInvalidTransform
+	}
+
+	t := &Transform{replace: replacement(parts[1])}
+	pattern := parts[0]
+
+	for _, flag := range parts[2] {
+		switch flag {
+		case 'g':
+			t.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return nil, ErrInvalidTransform
+		}
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	t.regex = regex
+
+	return t, nil
+}
+
+// Apply returns the name with the first or every match replaced.
+func (t *Transform) Apply(name string) string {
+	if t.global {
+		return t.regex.ReplaceAllString(name, t.replace)
+	}
+
+	match := t.regex.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name
+	}
+
+	b := []byte(name[:match[0]])
+	b = t.regex.ExpandString(b, t.replace, name, match)
+	return string(b) + name[match[1]:]
+}
+
+// split splits s on each delim not escaped with a backslash, removing
+// the backslash from escaped delimiters.
+func split(s string, delim byte) []string {
+	var parts []string
+	var part []byte
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			part = append(part, delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			part = append(part, s[i], s[i+1])
+			i++
+		case s[i] == delim:
+			parts = append(parts, string(part))
+			part = nil
+		default:
+			part = append(part, s[i])
+		}
+	}
+
+	return append(parts, string(part))
+}
+
+// replacement converts a sed replacement to a regexp template.
+func replacement(s string) string {
+	b := &strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			b.WriteString("${" + s[i+1:i+2] + "}")
+			i++
+		case c == '\\' && i+1 < len(s):
+			b.WriteByte(s[i+1])
+			i++
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
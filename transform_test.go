@@ -0,0 +1,68 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		expr string
+		name string
+		want string
+	}{
+		{"s/foo/bar/", "foo/foo", "bar/foo"},
+		{"s/foo/bar/g", "foo/foo", "bar/bar"},
+		{"s/FOO/bar/i", "foo/foo", "bar/foo"},
+		{"s|^home/([^/]*)|users/\\1|", "home/alice/file", "users/alice/file"},
+		{"s,^home/([^/]*),users/\\1,", "home/alice/file", "users/alice/file"},
+		{"s/\\//_/g", "a/b/c", "a_b_c"},
+		{"s/b/[&]/", "abc", "a[b]c"},
+		{"s/b/\\&$1/", "abc", "a&$1c"},
+		{"s/x/y/", "abc", "abc"},
+	}
+
+	for _, test := range tests {
+		transform, err := ParseTransform(test.expr)
+		if err != nil {
+			t.Fatal(test.expr, err)
+		}
+
+		if got := transform.Apply(test.name); got != test.want {
+			t.Fatalf("%s applied to %s got %s expected %s", test.expr, test.name, got, test.want)
+		}
+	}
+}
+
+func TestInvalidTransform(t *testing.T) {
+	for _, expr := range []string{"", "s", "x/a/b/", "s/a/b", "s/a/b/c/", "s/a/b/x", "s/(/b/"} {
+		if _, err := ParseTransform(expr); err == nil {
+			t.Fatal("accepted invalid transform", expr)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		prefix string
+	}{
+		{"/etc/passwd", "etc/passwd", "/"},
+		{"//etc", "etc", "/"},
+		{"../foo", "foo", "../"},
+		{"../../foo/./bar", "foo/bar", "../"},
+		{"a/../../b", "b", "../"},
+		{"a/./b/../c/", "a/c", ""},
+		{"..", ".", "../"},
+		{"/", ".", "/"},
+		{"foo", "foo", ""},
+	}
+
+	for _, test := range tests {
+		if name, prefix := normalize(test.name); name != test.want || prefix != test.prefix {
+			t.Fatalf("normalize %s got %s, %s", test.name, name, prefix)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"syscall"
+)
+
+const (
+	xattrPrefix = "SCHILY.xattr."
+	userXattrs  = "user."
+)
+
+// acls maps the xattrs holding ACLs to the PAX records storing them.
+var acls = map[string]string{
+	"system.posix_acl_access":  "SCHILY.acl.access",
+	"system.posix_acl_default": "SCHILY.acl.default",
+}
+
+// readXattrs stores the xattrs and ACLs of the file at path in the
+// header's PAX records. Filesystems without xattrs are ignored.
+func readXattrs(path string, h *tar.Header) error {
+	b, err := xattr(path, func(b []byte) (int, error) {
+		return syscall.Listxattr(path, b)
+	})
+
+	switch {
+	case err == syscall.ENOTSUP:
+		return nil
+	case err != nil:
+		return err
+	}
+
+	for _, name := range strings.Split(string(bytes.TrimRight(b, "\x00")), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		value, err := xattr(path, func(b []byte) (int, error) {
+			return syscall.Getxattr(path, name, b)
+		})
+
+		switch {
+		case err == syscall.ENODATA:
+			continue
+		case err != nil:
+			return err
+		}
+
+		if h.PAXRecords == nil {
+			h.PAXRecords = map[string]string{}
+		}
+
+		if record, ok := acls[name]; ok {
+			text, err := aclText(value)
+			if err != nil {
+				return err
+			}
+			h.PAXRecords[record] = text
+			continue
+		}
+
+		h.PAXRecords[xattrPrefix+name] = string(value)
+	}
+
+	return nil
+}
+
+// writeXattrs sets the ACLs and user xattrs stored in the header's PAX
+// records on the file at path, and the xattrs of every other namespace
+// when all is true. Those include security labels and trusted xattrs
+// that an archive from another host should not set by default.
+func writeXattrs(path string, h *tar.Header, all bool) error {
+	for name, record := range acls {
+		if text, ok := h.PAXRecords[record]; ok {
+			value, err := aclBinary(text)
+			if err != nil {
+				return err
+			}
+			if err = syscall.Setxattr(path, name, value, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	for record, value := range h.PAXRecords {
+		if !strings.HasPrefix(record, xattrPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(record, xattrPrefix)
+		if !all && !strings.HasPrefix(name, userXattrs) {
+			continue
+		}
+
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// xattr returns the result of a call that fills a buffer of the size
+// it returns when called with an empty buffer.
+func xattr(path string, call func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := call(nil)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+
+		b := make([]byte, n)
+		n, err = call(b)
+		switch {
+		case err == syscall.ERANGE:
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		return b[:n], nil
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestXattrs(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	src := filepath.Join(root, "src")
+	if err := ioutil.WriteFile(src, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	switch err := syscall.Setxattr(src, "user.arc", []byte("value"), 0); {
+	case err == syscall.ENOTSUP || err == syscall.EPERM:
+		t.Skip("xattrs not supported")
+	case err != nil:
+		t.Fatal(err)
+	}
+
+	h := &tar.Header{}
+	if err := readXattrs(src, h); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.PAXRecords["SCHILY.xattr.user.arc"] != "value" {
+		t.Fatal("xattr not read", h.PAXRecords)
+	}
+
+	dest := filepath.Join(root, "dest")
+	if err := os.Mkdir(dest, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Name = "file"
+	h.Typeflag = tar.TypeReg
+	h.Mode = 0600
+	arc := extractArchive(t, []*tar.Header{h})
+
+	if err := extractEntries(t, &Cmd{Root: dest}, arc); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 16)
+	n, err := syscall.Getxattr(filepath.Join(dest, "file"), "user.arc", b)
+	if err != nil || string(b[:n]) != "value" {
+		t.Fatal("xattr not restored", err)
+	}
+}
+
+func TestXattrNamespaces(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "file")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &tar.Header{PAXRecords: map[string]string{
+		"SCHILY.xattr.user.arc":    "user",
+		"SCHILY.xattr.trusted.arc": "trusted",
+	}}
+
+	switch err := writeXattrs(path, h, false); {
+	case err == syscall.ENOTSUP || err == syscall.EPERM:
+		t.Skip("xattrs not supported")
+	case err != nil:
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 16)
+	if n, err := syscall.Getxattr(path, "user.arc", b); err != nil || string(b[:n]) != "user" {
+		t.Fatal("user xattr not restored", err)
+	}
+
+	if _, err := syscall.Getxattr(path, "trusted.arc", b); err != syscall.ENODATA {
+		t.Fatal("trusted xattr restored without all", err)
+	}
+
+	switch err := writeXattrs(path, h, true); {
+	case err == syscall.ENOTSUP || err == syscall.EPERM:
+		t.Skip("trusted xattrs not supported")
+	case err != nil:
+		t.Fatal(err)
+	}
+
+	if n, err := syscall.Getxattr(path, "trusted.arc", b); err != nil || string(b[:n]) != "trusted" {
+		t.Fatal("trusted xattr not restored with all", err)
+	}
+}
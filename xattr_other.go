@@ -0,0 +1,23 @@
+// Copyright (C) 2016 - Will Glozer. All rights reserved.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"archive/tar"
+)
+
+// readXattrs stores the xattrs and ACLs of the file at path in the
+// header's PAX records, which is only supported on Linux.
+func readXattrs(path string, h *tar.Header) error {
+	return nil
+}
+
+// writeXattrs sets the ACLs and user xattrs, or with all every xattr,
+// stored in the header's PAX records on the file at path, which is only
+// supported on Linux.
+func writeXattrs(path string, h *tar.Header, all bool) error {
+	return nil
+}